@@ -0,0 +1,120 @@
+package ldap
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fileTimeEpochOffset is the number of 100ns intervals between the Windows
+// FILETIME epoch, 1601-01-01, and the Unix epoch.
+const fileTimeEpochOffset = 116444736000000000
+
+// fileTimeNever is the largest FILETIME, which Active Directory uses, along
+// with 0, for accounts that never expire.
+const fileTimeNever = "9223372036854775807"
+
+// SetAccountExpiration sets the time after which an account expires, in the
+// Active Directory accountExpires or the shadowExpire attribute. A zero time
+// removes the expiration. shadowExpire is a number of days, and accounts
+// expire at the start of that day, so t is rounded up to the next UTC day.
+func (lc *LDAPClient) SetAccountExpiration(userDN string, t time.Time) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	ad, err := lc.activeDirectory()
+	if err != nil {
+		return err
+	}
+
+	modifyRequest := ldap.NewModifyRequest(userDN, nil)
+	switch {
+	case ad && t.IsZero():
+		modifyRequest.Replace("accountExpires", []string{"0"})
+	case ad:
+		modifyRequest.Replace("accountExpires", []string{fileTime(t)})
+	case t.IsZero():
+		modifyRequest.Replace("shadowExpire", nil)
+	default:
+		modifyRequest.Replace("shadowExpire", []string{shadowDays(t)})
+	}
+	return lc.modify(modifyRequest)
+}
+
+// GetAccountExpiration returns the time after which an account expires, or
+// a zero time when it never does.
+func (lc *LDAPClient) GetAccountExpiration(userDN string) (time.Time, error) {
+	err := lc.connectAdmin()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	ad, err := lc.activeDirectory()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	attribute := "shadowExpire"
+	if ad {
+		attribute = "accountExpires"
+	}
+	entry, err := lc.getEntry(userDN, []string{attribute})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	value := entry.GetAttributeValue(attribute)
+	if ad {
+		return parseFileTime(value)
+	}
+	return parseShadowDays(value)
+}
+
+// fileTime returns t as a number of 100ns intervals since 1601-01-01 UTC.
+func fileTime(t time.Time) string {
+	return strconv.FormatInt(t.Unix()*1e7+int64(t.Nanosecond())/100+fileTimeEpochOffset, 10)
+}
+
+// parseFileTime parses a FILETIME, returning a zero time for the values
+// meaning never.
+func parseFileTime(value string) (time.Time, error) {
+	if value == "" || value == "0" || value == fileTimeNever {
+		return time.Time{}, nil
+	}
+
+	intervals, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid FILETIME %q: %v", value, err)
+	}
+	intervals -= fileTimeEpochOffset
+	return time.Unix(intervals/1e7, intervals%1e7*100).UTC(), nil
+}
+
+// shadowDays returns the number of days between the Unix epoch and t,
+// rounded up, as accounts expire once the current day reaches shadowExpire,
+// so that an account expiring at t is still valid until t.
+func shadowDays(t time.Time) string {
+	seconds := t.Unix()
+	if t.Nanosecond() > 0 {
+		seconds++
+	}
+	return strconv.FormatInt((seconds+86399)/86400, 10)
+}
+
+// parseShadowDays parses a number of days since the Unix epoch, returning a
+// zero time when empty or -1, and otherwise the start of that UTC day.
+func parseShadowDays(value string) (time.Time, error) {
+	if value == "" || value == "-1" {
+		return time.Time{}, nil
+	}
+
+	days, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid number of days %q: %v", value, err)
+	}
+	return time.Unix(days*86400, 0).UTC(), nil
+}
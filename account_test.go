@@ -0,0 +1,75 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileTime(t *testing.T) {
+	tests := []struct {
+		time  time.Time
+		value string
+	}{
+		{time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), "116444736000000000"},
+		{time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC), "133801631990000000"},
+		{time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC), "0"},
+	}
+
+	for _, test := range tests {
+		if value := fileTime(test.time); value != test.value {
+			t.Errorf("expected %s for %s, got %s", test.value, test.time, value)
+		}
+	}
+
+	for _, test := range tests[:2] {
+		parsed, err := parseFileTime(test.value)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.value, err)
+		}
+		if !parsed.Equal(test.time) {
+			t.Errorf("expected %s for %s, got %s", test.time, test.value, parsed)
+		}
+	}
+
+	// Accounts that never expire
+	for _, value := range []string{"0", fileTimeNever} {
+		if parsed, err := parseFileTime(value); err != nil || !parsed.IsZero() {
+			t.Errorf("expected a zero time for %s, got %s and %v", value, parsed, err)
+		}
+	}
+
+	if _, err := parseFileTime("never"); err == nil {
+		t.Errorf("expected an invalid FILETIME to fail")
+	}
+}
+
+func TestShadowDays(t *testing.T) {
+	tests := []struct {
+		time  time.Time
+		value string
+	}{
+		// Rounded up, so the account is still valid on the day of t
+		{time.Date(2024, 12, 31, 15, 0, 0, 0, time.UTC), "20089"},
+		{time.Date(2024, 12, 31, 23, 59, 59, 1, time.UTC), "20089"},
+		{time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), "20089"},
+		{time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC), "20090"},
+	}
+
+	for _, test := range tests {
+		if days := shadowDays(test.time); days != test.value {
+			t.Errorf("expected %s for %s, got %s", test.value, test.time, days)
+		}
+	}
+
+	parsed, err := parseShadowDays("20089")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC); !parsed.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, parsed)
+	}
+
+	if parsed, err := parseShadowDays("-1"); err != nil || !parsed.IsZero() {
+		t.Errorf("expected a zero time, got %s and %v", parsed, err)
+	}
+}
@@ -0,0 +1,201 @@
+package ldap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrAccountLocked is returned when binding as a user whose Active Directory
+// account is locked out after too many bad passwords, see UnlockAccount.
+var ErrAccountLocked = errors.New("Account locked")
+
+// Directory types, see LDAPClient.DirectoryType.
+const (
+	DirectoryOpenLDAP        = "OpenLDAP"
+	DirectoryActiveDirectory = "ActiveDirectory"
+)
+
+// activeDirectoryCapability is the LDAP_CAP_ACTIVE_DIRECTORY_OID advertised in
+// the supportedCapabilities of an Active Directory root DSE.
+const activeDirectoryCapability = "1.2.840.113556.1.4.800"
+
+// adGlobalSecurityGroup is the groupType of a global security group, the
+// GROUP_TYPE_ACCOUNT_GROUP and GROUP_TYPE_SECURITY_ENABLED flags as a signed
+// 32 bit integer.
+const adGlobalSecurityGroup = "-2147483646"
+
+// accountDisable is the ACCOUNTDISABLE flag of userAccountControl.
+const accountDisable = 0x2
+
+// DisableAccount disables an Active Directory account by setting the
+// ACCOUNTDISABLE flag of its userAccountControl.
+func (lc *LDAPClient) DisableAccount(userDN string) error {
+	return lc.changeUserAccountControl(userDN, accountDisable, true)
+}
+
+// EnableAccount enables an Active Directory account by clearing the
+// ACCOUNTDISABLE flag of its userAccountControl.
+func (lc *LDAPClient) EnableAccount(userDN string) error {
+	return lc.changeUserAccountControl(userDN, accountDisable, false)
+}
+
+// activeDirectory reports whether the directory is Active Directory, as
+// set by DirectoryType or else advertised by the root DSE.
+func (lc *LDAPClient) activeDirectory() (bool, error) {
+	if lc.DirectoryType != "" {
+		return lc.DirectoryType == DirectoryActiveDirectory, nil
+	}
+	return lc.isActiveDirectory()
+}
+
+// adUserFilter returns the filter matching an Active Directory user by logon
+// name: the sAMAccountName of DOMAIN\user or user, or the userPrincipalName of
+// user@domain.
+func adUserFilter(username string) string {
+	if i := strings.LastIndex(username, `\`); i >= 0 {
+		return fmt.Sprintf("(sAMAccountName=%s)", ldap.EscapeFilter(username[i+1:]))
+	}
+	if strings.Contains(username, "@") {
+		return fmt.Sprintf("(userPrincipalName=%s)", ldap.EscapeFilter(username))
+	}
+	return fmt.Sprintf("(sAMAccountName=%s)", ldap.EscapeFilter(username))
+}
+
+// adLogonName returns the name Active Directory binds a user with: DOMAIN\user
+// and user@domain as is, and a bare sAMAccountName qualified with Domain.
+func (lc *LDAPClient) adLogonName(username string) string {
+	if lc.Domain == "" || strings.ContainsAny(username, `\@`) {
+		return username
+	}
+	return lc.Domain + `\` + username
+}
+
+// GUIDToString returns the string form of a binary objectGUID, e.g.
+// "{3f2504e0-4f89-11d3-9a0c-0305e82c3301}", whose first three groups are
+// stored little endian. It returns an empty string when guid is not 16 bytes.
+func GUIDToString(guid []byte) string {
+	if len(guid) != 16 {
+		return ""
+	}
+	return fmt.Sprintf("{%08x-%04x-%04x-%x-%x}",
+		binary.LittleEndian.Uint32(guid[0:4]),
+		binary.LittleEndian.Uint16(guid[4:6]),
+		binary.LittleEndian.Uint16(guid[6:8]),
+		guid[8:10],
+		guid[10:16],
+	)
+}
+
+// SIDToString returns the string form of a binary objectSid, e.g.
+// "S-1-5-21-3623811015-3361044348-30300820-1013". It returns an empty string
+// when sid is truncated.
+func SIDToString(sid []byte) string {
+	if len(sid) < 8 || len(sid) != 8+4*int(sid[1]) {
+		return ""
+	}
+
+	// The identifier authority is a 48 bit big endian integer
+	authority := uint64(0)
+	for _, b := range sid[2:8] {
+		authority = authority<<8 | uint64(b)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "S-%d-%d", sid[0], authority)
+	for i := 8; i < len(sid); i += 4 {
+		fmt.Fprintf(&b, "-%d", binary.LittleEndian.Uint32(sid[i:i+4]))
+	}
+	return b.String()
+}
+
+// UnlockAccount unlocks an Active Directory account locked out after too many
+// bad passwords by resetting its lockoutTime.
+func (lc *LDAPClient) UnlockAccount(userDN string) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	modifyRequest := ldap.NewModifyRequest(userDN, nil)
+	modifyRequest.Replace("lockoutTime", []string{"0"})
+	return lc.modify(modifyRequest)
+}
+
+// IsAccountLocked reports whether an Active Directory account is locked out,
+// i.e. its lockoutTime is set.
+func (lc *LDAPClient) IsAccountLocked(userDN string) (bool, error) {
+	entry, err := lc.getEntry(userDN, []string{"lockoutTime"})
+	if err != nil {
+		return false, err
+	}
+
+	lockoutTime := entry.GetAttributeValue("lockoutTime")
+	return lockoutTime != "" && lockoutTime != "0", nil
+}
+
+// accountLocked reports whether a bind failed because the Active Directory
+// account is locked out, which the diagnostic message tells with the 775
+// sub-code, e.g. "AcceptSecurityContext error, data 775, v4563".
+func accountLocked(err error) bool {
+	return ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) && strings.Contains(err.Error(), "data 775,")
+}
+
+// changeUserAccountControl sets or clears a flag of the userAccountControl of
+// a user, preserving the other flags.
+func (lc *LDAPClient) changeUserAccountControl(userDN string, flag int, set bool) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	entry, err := lc.readEntry(userDN, []string{"userAccountControl"})
+	if err != nil {
+		return err
+	}
+
+	current := entry.GetAttributeValue("userAccountControl")
+	updated, err := updateUserAccountControl(current, flag, set)
+	if err != nil {
+		return err
+	}
+	if updated == current {
+		return nil
+	}
+
+	modifyRequest := ldap.NewModifyRequest(userDN, nil)
+	modifyRequest.Replace("userAccountControl", []string{updated})
+	return lc.modify(modifyRequest)
+}
+
+// updateUserAccountControl returns the userAccountControl value with the flag
+// set or cleared.
+func updateUserAccountControl(value string, flag int, set bool) (string, error) {
+	uac, err := strconv.Atoi(value)
+	if err != nil {
+		return "", fmt.Errorf("Invalid userAccountControl %q: %v", value, err)
+	}
+
+	if set {
+		uac |= flag
+	} else {
+		uac &^= flag
+	}
+	return strconv.Itoa(uac), nil
+}
+
+// encodeADPassword returns password in the unicodePwd format, i.e. surrounded
+// by double quotes and encoded in UTF-16LE.
+func encodeADPassword(password string) string {
+	encoded := utf16.Encode([]rune("\"" + password + "\""))
+	b := make([]byte, 2*len(encoded))
+	for i, r := range encoded {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	return string(b)
+}
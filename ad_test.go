@@ -0,0 +1,130 @@
+package ldap
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestEncodeADPassword(t *testing.T) {
+	expected := "\"\x00s\x00e\x00c\x00r\x00e\x00t\x00\"\x00"
+	if encoded := encodeADPassword("secret"); encoded != expected {
+		t.Errorf("expected %q, got %q", expected, encoded)
+	}
+
+	// Characters outside the BMP are encoded as surrogate pairs
+	expected = "\"\x00\x3d\xd8\x00\xde\"\x00"
+	if encoded := encodeADPassword("\U0001F600"); encoded != expected {
+		t.Errorf("expected %q, got %q", expected, encoded)
+	}
+}
+
+func TestUpdateUserAccountControl(t *testing.T) {
+	tests := []struct {
+		value    string
+		set      bool
+		expected string
+	}{
+		// NORMAL_ACCOUNT
+		{"512", true, "514"},
+		{"514", false, "512"},
+		// NORMAL_ACCOUNT | DONT_EXPIRE_PASSWORD is preserved
+		{"66048", true, "66050"},
+		{"66050", false, "66048"},
+		// Already in the expected state
+		{"514", true, "514"},
+		{"512", false, "512"},
+	}
+
+	for _, test := range tests {
+		updated, err := updateUserAccountControl(test.value, accountDisable, test.set)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.value, err)
+		}
+		if updated != test.expected {
+			t.Errorf("expected %s for %s, got %s", test.expected, test.value, updated)
+		}
+	}
+
+	if _, err := updateUserAccountControl("", accountDisable, true); err == nil {
+		t.Errorf("expected an error for an empty userAccountControl")
+	}
+}
+
+func TestAccountLocked(t *testing.T) {
+	tests := []struct {
+		err    error
+		locked bool
+	}{
+		{&ldap.Error{ResultCode: ldap.LDAPResultInvalidCredentials, Err: errors.New("80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 775, v4563")}, true},
+		{&ldap.Error{ResultCode: ldap.LDAPResultInvalidCredentials, Err: errors.New("80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 52e, v4563")}, false},
+		{&ldap.Error{ResultCode: ldap.LDAPResultInvalidCredentials, Err: errors.New("Invalid credentials")}, false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if locked := accountLocked(test.err); locked != test.locked {
+			t.Errorf("expected %v for %v, got %v", test.locked, test.err, locked)
+		}
+	}
+}
+
+func TestADLogin(t *testing.T) {
+	tests := []struct {
+		username, filter, logonName string
+	}{
+		{"alice", "(sAMAccountName=alice)", `EXAMPLE\alice`},
+		{`EXAMPLE\alice`, "(sAMAccountName=alice)", `EXAMPLE\alice`},
+		{"alice@example.com", "(userPrincipalName=alice@example.com)", "alice@example.com"},
+		{"*", `(sAMAccountName=\2a)`, `EXAMPLE\*`},
+	}
+	lc := &LDAPClient{DirectoryType: DirectoryActiveDirectory, Domain: "EXAMPLE"}
+	for _, test := range tests {
+		if filter := lc.userFilter(test.username); filter != test.filter {
+			t.Errorf("expected filter %q for %q, got %q", test.filter, test.username, filter)
+		}
+		if name := lc.bindUserName(test.username); name != test.logonName {
+			t.Errorf("expected logon name %q for %q, got %q", test.logonName, test.username, name)
+		}
+	}
+
+	// A configured filter still applies
+	lc.UserFilter = "(cn=%s)"
+	if filter := lc.userFilter("alice"); filter != "(cn=alice)" {
+		t.Errorf("expected the configured filter, got %q", filter)
+	}
+}
+
+func TestObjectIDs(t *testing.T) {
+	guid := []byte("\xe0\x04\x25\x3f\x89\x4f\xd3\x11\x9a\x0c\x03\x05\xe8\x2c\x33\x01")
+	if s := GUIDToString(guid); s != "{3f2504e0-4f89-11d3-9a0c-0305e82c3301}" {
+		t.Errorf("unexpected GUID %s", s)
+	}
+	if s := GUIDToString(guid[:15]); s != "" {
+		t.Errorf("expected no GUID for 15 bytes, got %s", s)
+	}
+
+	sid := []byte("\x01\x05\x00\x00\x00\x00\x00\x05\x15\x00\x00\x00\xc7\xf7\xfe\xd7\x7c\x77\x55\xc8\x94\x5a\xce\x01\xf5\x03\x00\x00")
+	if s := SIDToString(sid); s != "S-1-5-21-3623811015-3361044348-30300820-1013" {
+		t.Errorf("unexpected SID %s", s)
+	}
+	if s := SIDToString(sid[:len(sid)-1]); s != "" {
+		t.Errorf("expected no SID when truncated, got %s", s)
+	}
+
+	entry := &ldap.Entry{Attributes: []*ldap.EntryAttribute{
+		{Name: "objectGUID", Values: []string{string(guid)}, ByteValues: [][]byte{guid}},
+		{Name: "cn", Values: []string{"alice"}, ByteValues: [][]byte{[]byte("alice")}},
+	}}
+	decodeObjectIDs(entry)
+	if values := entry.GetAttributeValues("objectGUID"); len(values) != 1 || values[0] != "{3f2504e0-4f89-11d3-9a0c-0305e82c3301}" {
+		t.Errorf("expected the decoded GUID, got %q", values)
+	}
+	if raw := entry.GetRawAttributeValue("objectGUID"); string(raw) != string(guid) {
+		t.Errorf("expected the raw GUID to be kept")
+	}
+	if value := entry.GetAttributeValue("cn"); value != "alice" {
+		t.Errorf("expected cn to be left as is, got %q", value)
+	}
+}
@@ -0,0 +1,122 @@
+package ldap
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// searchCache caches search results for a TTL, it is safe for concurrent use
+// and a nil cache caches nothing.
+type searchCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	results map[string]cachedResult
+}
+
+type cachedResult struct {
+	values  []string
+	expires time.Time
+}
+
+func newSearchCache(ttl time.Duration) *searchCache {
+	return &searchCache{ttl: ttl, results: map[string]cachedResult{}}
+}
+
+// cacheMu guards the creation of the caches of the clients, which is lazy
+// for the clients not created by New or connected yet.
+var cacheMu sync.Mutex
+
+// searchCache returns the cache of the client, created with CacheTTL, or nil.
+func (lc *LDAPClient) searchCache() *searchCache {
+	if lc.CacheTTL <= 0 {
+		return nil
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if lc.cache == nil {
+		lc.cache = newSearchCache(lc.CacheTTL)
+	}
+	return lc.cache
+}
+
+// ClearCache drops the cached search results, e.g. after the directory was
+// changed by another client.
+func (lc *LDAPClient) ClearCache() {
+	lc.invalidateCache()
+}
+
+// invalidateCache drops the cached search results after a write.
+func (lc *LDAPClient) invalidateCache() {
+	cacheMu.Lock()
+	cache := lc.cache
+	cacheMu.Unlock()
+	if cache != nil {
+		cache.clear()
+	}
+}
+
+// cacheKey identifies a search by its bases, filter, attributes and options,
+// along with the identity and the controls it runs with, including
+// ProxiedAuthzID, as they change the entries that the directory returns.
+func (lc *LDAPClient) cacheKey(filter string, attributes []string, options SearchOptions) string {
+	controls := []string{}
+	for _, control := range lc.controls(nil) {
+		controls = append(controls, control.String())
+	}
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%v\x00%s\x00%s",
+		strings.Join(lc.searchBases(), ";"), filter, strings.Join(attributes, ","), options,
+		lc.identity(), strings.Join(controls, ";"))
+}
+
+// identity returns the identity the searches of the client run as: the one
+// the connection is bound as, or the read only user it is bound with once
+// connected.
+func (lc *LDAPClient) identity() string {
+	if lc.Conn != nil && !lc.boundAsBindUser {
+		return "dn:" + lc.boundDN
+	}
+	return "bind:" + lc.BindDN
+}
+
+// get returns a copy of the unexpired result cached under key.
+func (c *searchCache) get(key string) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, ok := c.results[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(result.expires) {
+		delete(c.results, key)
+		return nil, false
+	}
+	return append([]string{}, result.values...), true
+}
+
+// put caches a copy of values under key.
+func (c *searchCache) put(key string, values []string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results[key] = cachedResult{
+		values:  append([]string{}, values...),
+		expires: time.Now().Add(c.ttl),
+	}
+}
+
+// clear drops every cached result.
+func (c *searchCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = map[string]cachedResult{}
+}
@@ -0,0 +1,106 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSearchCache(t *testing.T) {
+	cache := newSearchCache(time.Hour)
+	values := []string{"admins", "users"}
+	cache.put("key", values)
+	values[0] = "changed"
+
+	cached, ok := cache.get("key")
+	if !ok || !reflect.DeepEqual(cached, []string{"admins", "users"}) {
+		t.Errorf("expected the cached values, got %v", cached)
+	}
+	if _, ok := cache.get("other"); ok {
+		t.Errorf("expected no values for another key")
+	}
+
+	cache.clear()
+	if _, ok := cache.get("key"); ok {
+		t.Errorf("expected no values after clear")
+	}
+
+	// Expired results are dropped
+	cache.ttl = -time.Second
+	cache.put("key", values)
+	if _, ok := cache.get("key"); ok || len(cache.results) != 0 {
+		t.Errorf("expected the expired values to be dropped")
+	}
+
+	// A nil cache caches nothing
+	var none *searchCache
+	none.put("key", values)
+	if _, ok := none.get("key"); ok {
+		t.Errorf("expected no values from a nil cache")
+	}
+}
+
+func TestFilterCache(t *testing.T) {
+	// Not connected, the result can only come from the cache
+	lc, err := New(WithHost("127.0.0.1", 0), WithBase("dc=example,dc=com"), WithGroupFilter("(memberUid=%s)"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lc.CacheTTL = time.Hour
+	key := lc.cacheKey("(memberUid=alice)", []string{"cn"}, lc.searchOptions())
+	lc.searchCache().put(key, []string{"admins"})
+
+	groups, err := lc.GetGroupsOfUser("alice")
+	if err != nil || !reflect.DeepEqual(groups, []string{"admins"}) {
+		t.Errorf("expected the cached groups, got %v, %v", groups, err)
+	}
+
+	// Copies of the client, e.g. pooled ones, share the cache
+	client := *lc
+	client.ClearCache()
+	if _, ok := lc.searchCache().get(key); ok {
+		t.Errorf("expected the shared cache to be cleared")
+	}
+
+	// Another authorization identity does not get the cached result
+	client.ProxiedAuthzID = "u:bob"
+	if client.cacheKey("(memberUid=alice)", []string{"cn"}, client.searchOptions()) == key {
+		t.Errorf("expected the proxied authorization to change the key")
+	}
+	client.ProxiedAuthzID = ""
+	client.BindDN = "cn=reader,dc=example,dc=com"
+	if client.cacheKey("(memberUid=alice)", []string{"cn"}, client.searchOptions()) == key {
+		t.Errorf("expected the bind user to change the key")
+	}
+}
+
+func TestSearchCacheCreation(t *testing.T) {
+	// Copies taken before the first search share the cache created by New
+	lc, err := New(WithHost("127.0.0.1", 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lc.cache != nil {
+		t.Fatalf("expected no cache without CacheTTL")
+	}
+	lc, err = New(WithHost("127.0.0.1", 0), func(lc *LDAPClient) { lc.CacheTTL = time.Hour })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := *lc
+	if client.searchCache() != lc.searchCache() {
+		t.Errorf("expected the copy to share the cache")
+	}
+
+	// Concurrent searches create a single cache
+	lc = &LDAPClient{CacheTTL: time.Hour}
+	caches := make(chan *searchCache, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			caches <- lc.searchCache()
+		}()
+	}
+	if <-caches != <-caches {
+		t.Errorf("expected a single cache")
+	}
+}
@@ -0,0 +1,65 @@
+package ldap
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// EscapeDN escapes a value to be used as an attribute value in a DN, following
+// RFC 4514 section 2.4, e.g. EscapeDN("Smith, John") returns `Smith\, John`.
+func EscapeDN(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '+' || c == ',' || c == ';' || c == '<' || c == '>' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		case i == 0 && (c == ' ' || c == '#'):
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case i == len(value)-1 && c == ' ':
+			b.WriteString(`\ `)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// NormalizeDN returns a canonical form of a DN, so that DNs differing only in
+// spacing, case, escaping or the order of multi-valued RDNs compare equal,
+// e.g. "CN=Alice, OU=People" and "cn=alice,ou=people". Values are lowercased,
+// as the naming attributes of most directories match case insensitively.
+func NormalizeDN(dn string) (string, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return "", err
+	}
+
+	rdns := make([]string, 0, len(parsed.RDNs))
+	for _, rdn := range parsed.RDNs {
+		attributes := make([]string, 0, len(rdn.Attributes))
+		for _, attr := range rdn.Attributes {
+			attributes = append(attributes, strings.ToLower(attr.Type)+"="+EscapeDN(strings.ToLower(attr.Value)))
+		}
+		sort.Strings(attributes)
+		rdns = append(rdns, strings.Join(attributes, "+"))
+	}
+	return strings.Join(rdns, ","), nil
+}
+
+// DNEqual reports whether two DNs are equal once normalized, falling back on
+// a case insensitive comparison when either cannot be parsed.
+func DNEqual(a, b string) bool {
+	normalizedA, errA := NormalizeDN(a)
+	normalizedB, errB := NormalizeDN(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	return normalizedA == normalizedB
+}
@@ -0,0 +1,64 @@
+package ldap
+
+import (
+	"testing"
+)
+
+func TestEscapeDN(t *testing.T) {
+	tests := []struct {
+		value, expected string
+	}{
+		{"alice", "alice"},
+		{"Smith, John", `Smith\, John`},
+		{"a+b=c", `a\+b=c`},
+		{`back\slash`, `back\\slash`},
+		{`"quoted";<tag>`, `\"quoted\"\;\<tag\>`},
+		{"#hash", `\#hash`},
+		{"mid#hash", "mid#hash"},
+		{" padded ", `\ padded\ `},
+		{"nul\x00", `nul\00`},
+		{"uid=admin,dc=example", `uid=admin\,dc=example`},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if escaped := EscapeDN(test.value); escaped != test.expected {
+			t.Errorf("expected %s for %q, got %s", test.expected, test.value, escaped)
+		}
+	}
+}
+
+func TestNormalizeDN(t *testing.T) {
+	tests := []struct {
+		dn, expected string
+	}{
+		{"CN=Alice, OU=People,DC=Example,DC=com", "cn=alice,ou=people,dc=example,dc=com"},
+		{`cn=Smith\, John,dc=com`, `cn=smith\, john,dc=com`},
+		{`cn=Smith\2C John,dc=com`, `cn=smith\, john,dc=com`},
+		{"uid=bob+cn=Bob,dc=com", "cn=bob+uid=bob,dc=com"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		normalized, err := NormalizeDN(test.dn)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", test.dn, err)
+		}
+		if normalized != test.expected {
+			t.Errorf("expected %s for %q, got %s", test.expected, test.dn, normalized)
+		}
+	}
+
+	if _, err := NormalizeDN("cn"); err == nil {
+		t.Errorf("expected an invalid DN to fail")
+	}
+}
+
+func TestDNEqual(t *testing.T) {
+	if !DNEqual("CN=Alice, OU=People", "cn=alice,ou=people") {
+		t.Errorf("expected DNs differing in case and spacing to be equal")
+	}
+	if DNEqual("cn=alice,ou=people", "cn=bob,ou=people") {
+		t.Errorf("expected different DNs not to be equal")
+	}
+}
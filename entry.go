@@ -0,0 +1,93 @@
+package ldap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// binaryAttributes lists well known attributes holding binary values.
+var binaryAttributes = map[string]bool{
+	"objectguid":        true,
+	"objectsid":         true,
+	"jpegphoto":         true,
+	"thumbnailphoto":    true,
+	"usercertificate":   true,
+	"cacertificate":     true,
+	"msexchmailboxguid": true,
+}
+
+// JSONEntry is the JSON representation of an entry.
+type JSONEntry struct {
+	DN         string              `json:"dn"`
+	Attributes map[string][]string `json:"attributes"`
+}
+
+// EntryToMap returns the attribute values of an entry. Binary values, e.g.
+// objectGUID or jpegPhoto, are base64 encoded.
+func EntryToMap(entry *ldap.Entry) map[string][]string {
+	attributes := map[string][]string{}
+	for _, attr := range entry.Attributes {
+		binary := isBinaryAttribute(attr.Name)
+		values := make([]string, 0, len(attr.ByteValues))
+		for _, value := range attr.ByteValues {
+			if binary || !utf8.Valid(value) {
+				values = append(values, base64.StdEncoding.EncodeToString(value))
+			} else {
+				values = append(values, string(value))
+			}
+		}
+		attributes[attr.Name] = values
+	}
+	return attributes
+}
+
+// MarshalEntry returns the JSON encoding of an entry, see JSONEntry.
+func MarshalEntry(entry *ldap.Entry) ([]byte, error) {
+	return json.Marshal(JSONEntry{DN: entry.DN, Attributes: EntryToMap(entry)})
+}
+
+// MarshalEntries returns the JSON encoding of entries as an array.
+func MarshalEntries(entries []*ldap.Entry) ([]byte, error) {
+	list := make([]JSONEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, JSONEntry{DN: entry.DN, Attributes: EntryToMap(entry)})
+	}
+	return json.Marshal(list)
+}
+
+// isBinaryAttribute reports whether an attribute is known to be binary,
+// including the attributes transferred with the ";binary" option.
+func isBinaryAttribute(name string) bool {
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, ";binary") {
+		return true
+	}
+	return binaryAttributes[name]
+}
+
+// decodeObjectIDs replaces the values of the objectGUID and objectSid
+// attributes of an entry with their string forms, keeping the raw ones in
+// ByteValues.
+func decodeObjectIDs(entry *ldap.Entry) {
+	for _, attr := range entry.Attributes {
+		var decode func([]byte) string
+		switch strings.ToLower(attr.Name) {
+		case "objectguid":
+			decode = GUIDToString
+		case "objectsid":
+			decode = SIDToString
+		default:
+			continue
+		}
+
+		values := make([]string, 0, len(attr.ByteValues))
+		for _, value := range attr.ByteValues {
+			values = append(values, decode(value))
+		}
+		attr.Values = values
+	}
+}
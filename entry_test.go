@@ -0,0 +1,41 @@
+package ldap
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestMarshalEntry(t *testing.T) {
+	entry := &ldap.Entry{
+		DN: "cn=alice,ou=people,dc=example,dc=com",
+		Attributes: []*ldap.EntryAttribute{
+			{Name: "cn", Values: []string{"alice"}, ByteValues: [][]byte{[]byte("alice")}},
+			{Name: "mail", Values: []string{"a@example.com", "alice@example.com"}, ByteValues: [][]byte{[]byte("a@example.com"), []byte("alice@example.com")}},
+			{Name: "objectGUID", Values: []string{"abc"}, ByteValues: [][]byte{[]byte("abc")}},
+			{Name: "photo", Values: []string{"\xff\xd8\xff"}, ByteValues: [][]byte{{0xff, 0xd8, 0xff}}},
+		},
+	}
+
+	b, err := MarshalEntry(entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `{"dn":"cn=alice,ou=people,dc=example,dc=com","attributes":{` +
+		`"cn":["alice"],"mail":["a@example.com","alice@example.com"],"objectGUID":["YWJj"],"photo":["/9j/"]}}`
+	if string(b) != expected {
+		t.Errorf("expected %s, got %s", expected, b)
+	}
+}
+
+func TestIsBinaryAttribute(t *testing.T) {
+	for _, name := range []string{"objectGUID", "objectSid", "jpegPhoto", "userCertificate;binary"} {
+		if !isBinaryAttribute(name) {
+			t.Errorf("expected %s to be binary", name)
+		}
+	}
+	if isBinaryAttribute("cn") {
+		t.Errorf("expected cn not to be binary")
+	}
+}
@@ -1,8 +1,6 @@
 package ldap_test
 
 import (
-	"crypto/md5"
-	"encoding/base64"
 	"log"
 
 	"github.com/f-minzoni/go-ldap-client"
@@ -50,8 +48,8 @@ func ExampleLDAPClient_GetGroupsOfUser() {
 	log.Printf("Groups: %+v", groups)
 }
 
-// ExampleLDAPClient_GetUsers shows how to retrieve users
-func ExampleLDAPClient_GetUsers() {
+// ExampleLDAPClient_Filter_users shows how to retrieve users
+func ExampleLDAPClient_Filter_users() {
 	client := &ldap.LDAPClient{
 		Base: "dc=example,dc=com",
 		Host: "ldap.example.com",
@@ -65,8 +63,8 @@ func ExampleLDAPClient_GetUsers() {
 	log.Printf("Users: %+v", users)
 }
 
-// ExampleLDAPClient_GetGroups shows how to retrieve groups
-func ExampleLDAPClient_GetGroups() {
+// ExampleLDAPClient_Filter_groups shows how to retrieve groups
+func ExampleLDAPClient_Filter_groups() {
 	client := &ldap.LDAPClient{
 		Base: "dc=example,dc=com",
 		Host: "ldap.example.com",
@@ -90,10 +88,7 @@ func ExampleLDAPClient_AddUser() {
 	}
 	defer client.Close()
 
-	hash := md5.New()
-	hash.Write([]byte("supersecret"))
-	b := hash.Sum(nil)
-	password := "{MD5}" + base64.StdEncoding.EncodeToString(b)
+	password := ldap.HashSSHA("supersecret")
 	err := client.AddUser("newuser", password, "people")
 	if err != nil {
 		log.Fatalf("Error adding user: %+v", err)
@@ -0,0 +1,36 @@
+package ldap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Filterf substitutes the named placeholders of a filter template, e.g.
+// "(&(uid={username})(ou={ou}))", with the escaped values of args, so that
+// they cannot alter the filter. Placeholders without value are left as is.
+func Filterf(template string, args map[string]string) string {
+	// Sort the names so that the replacements do not depend on map order
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, 2*len(args))
+	for _, name := range names {
+		pairs = append(pairs, "{"+name+"}", ldap.EscapeFilter(args[name]))
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+// expandFilter fills a configured filter with username, either a named
+// {username} placeholder or a positional %s.
+func expandFilter(filter, username string) string {
+	if strings.Contains(filter, "{username}") {
+		return Filterf(filter, map[string]string{"username": username})
+	}
+	return fmt.Sprintf(filter, ldap.EscapeFilter(username))
+}
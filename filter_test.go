@@ -0,0 +1,32 @@
+package ldap
+
+import "testing"
+
+func TestFilterf(t *testing.T) {
+	tests := []struct {
+		template string
+		args     map[string]string
+		expected string
+	}{
+		{"(uid={username})", map[string]string{"username": "alice"}, "(uid=alice)"},
+		{"(uid={username})", map[string]string{"username": "*)(uid=*"}, `(uid=\2a\29\28uid=\2a)`},
+		{"(&(uid={username})(ou={ou}))", map[string]string{"username": "alice", "ou": "people"}, "(&(uid=alice)(ou=people))"},
+		// Values are not substituted again
+		{"(|(cn={a})(cn={b}))", map[string]string{"a": "{b}", "b": "bob"}, "(|(cn={b})(cn=bob))"},
+		{"(uid={unknown})", map[string]string{"username": "alice"}, "(uid={unknown})"},
+	}
+	for _, test := range tests {
+		if filter := Filterf(test.template, test.args); filter != test.expected {
+			t.Errorf("expected %q for %q, got %q", test.expected, test.template, filter)
+		}
+	}
+}
+
+func TestExpandFilter(t *testing.T) {
+	if filter := expandFilter("(memberUid={username})", "a*"); filter != `(memberUid=a\2a)` {
+		t.Errorf("unexpected filter %q", filter)
+	}
+	if filter := expandFilter("(memberUid=%s)", "a*"); filter != `(memberUid=a\2a)` {
+		t.Errorf("unexpected filter %q", filter)
+	}
+}
@@ -3,13 +3,19 @@
 package ldap
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
-	"gopkg.in/ldap.v2"
+	"github.com/go-ldap/ldap/v3"
 )
 
 type LDAPClient struct {
@@ -17,316 +23,3045 @@ type LDAPClient struct {
 	Base               string
 	BindDN             string
 	BindPassword       string
-	GroupFilter        string // e.g. "(memberUid=%s)"
+	GroupFilter        string // e.g. "(memberUid=%s)" or "(memberUid={username})"
 	Host               string
 	ServerName         string
-	UserFilter         string // e.g. "(uid=%s)"
+	UserFilter         string // e.g. "(uid=%s)" or "(uid={username})"
 	Conn               *ldap.Conn
 	Port               int
 	InsecureSkipVerify bool
 	UseSSL             bool
 	SkipTLS            bool
+	// UseMatchingRuleInChain resolves nested groups server side with the
+	// Active Directory LDAP_MATCHING_RULE_IN_CHAIN matching rule.
+	UseMatchingRuleInChain bool
+	// GroupObjectClass is the objectClass used by CreateGroup, either
+	// "posixGroup" (default) or "groupOfNames".
+	GroupObjectClass string
+	// HashPassword hashes the plaintext passwords given to AddUser,
+	// AddUserAccount and ChangePassword, e.g. HashSSHA. When nil passwords
+	// are stored as given, so they can be hashed beforehand.
+	HashPassword func(password string) string
+	// UserDNTemplate and GroupDNTemplate build the DN of a user or group from
+	// its name, OU and the base, in that order, e.g. "uid=%s,ou=%s,%s".
+	// Both default to "cn=%s,ou=%s,%s". The name and OU are escaped.
+	UserDNTemplate  string
+	GroupDNTemplate string
+	// Logger, when set, is told about every operation sent to the ldap
+	// backend along with its duration. Passwords are never logged.
+	Logger Logger
+	// Observe, when set, is called after every operation sent to the ldap
+	// backend, including failed connection attempts and rebinds.
+	Observe ObserveFunc
+	// AllowUnauthenticatedBind permits binds with a DN and an empty password,
+	// which most servers treat as anonymous binds that always succeed.
+	AllowUnauthenticatedBind bool
+	// Hosts lists replicas to fail over to, it takes precedence over Host.
+	Hosts     []string
+	hostIndex int
+	// FollowReferrals chases the referrals returned by searches, binding with
+	// BindDN on the referred servers, and merges their entries. At most
+	// MaxReferralHops referrals are followed in a row (5 by default).
+	FollowReferrals bool
+	MaxReferralHops int
+	referralHops    int
+	// SearchOptions tunes the searches under the base, nil means a subtree
+	// search never dereferencing aliases and without limits.
+	SearchOptions *SearchOptions
+	// URL, e.g. "ldaps://ldap.example.com:636", takes precedence over Host,
+	// Port, UseSSL and Hosts. ServerName defaults to its host.
+	URL string
+	// AuthMethod selects how the read only user binds: AuthSimple (default),
+	// AuthDigestMD5 with BindDN as the SASL username, AuthNTLM with BindDN as
+	// the username in Domain, or AuthGSSAPI with the Kerberos ticket cache.
+	// Authenticate verifies user passwords with DIGEST-MD5 or NTLM when
+	// selected, or with a simple bind otherwise.
+	AuthMethod string
+	Domain     string
+	// Krb5ConfPath and Krb5CCachePath locate the Kerberos configuration and
+	// ticket cache used by GSSAPI binds, defaulting to /etc/krb5.conf and
+	// $KRB5CCNAME or /tmp/krb5cc_<uid>.
+	Krb5ConfPath   string
+	Krb5CCachePath string
+	// Krb5KeytabPath, when set, makes GSSAPI binds use the keys of
+	// Krb5Principal, e.g. "svc-sync@EXAMPLE.COM" or "svc-sync" in Krb5Realm,
+	// from that keytab instead of the ticket cache, e.g. for daemons.
+	Krb5KeytabPath string
+	Krb5Principal  string
+	Krb5Realm      string
+	// ServicePrincipal is the SPN of the directory used by GSSAPI binds,
+	// defaulting to "ldap/<host>".
+	ServicePrincipal string
+	// GroupMemberAttribute holds the members of a group: "memberUid"
+	// (default) with uids, or "member" and "uniqueMember" with DNs, which
+	// ResolveMemberDNs resolves to uids when reading the members and from
+	// uids when changing them.
+	GroupMemberAttribute string
+	ResolveMemberDNs     bool
+	// VerifyOnSeparateConnection makes Authenticate check passwords on a
+	// dedicated connection like VerifyPassword, never binding the shared
+	// connection as the user, while failing with the same errors.
+	VerifyOnSeparateConnection bool
+	// DryRun builds and logs the add, modify, delete and password modify
+	// requests without sending them, and reports them to OnDryRun, if set.
+	DryRun   bool
+	OnDryRun func(request interface{})
+	// ManageDsaIT attaches the ManageDsaIT control to searches, adds,
+	// modifies and deletes, so that referral objects are treated as normal
+	// entries instead of being followed or returned as referrals.
+	ManageDsaIT bool
+	// Controls are attached to every search, add, modify and delete, e.g. a
+	// tree delete or password policy request control.
+	Controls []ldap.Control
+	// ProxiedAuthzID, e.g. "dn:uid=alice,ou=people,dc=example,dc=com",
+	// attaches the proxied authorization control to every search, add,
+	// modify and delete, so that they are performed as that identity by a
+	// bind user granted the right to.
+	ProxiedAuthzID string
+	// BindUserDNTemplate, e.g. "uid=%s,ou=people,dc=example,dc=com", lets
+	// Authenticate bind directly as the user and read their attributes with
+	// that bind when no read only user is configured. The username is
+	// escaped. Active Directory also accepts a user principal name, e.g.
+	// "%s@example.com", then the user is searched for by it under the base.
+	BindUserDNTemplate string
+	// RetryPolicy, when set, retries connections, searches and operations
+	// that failed with a transient error.
+	RetryPolicy *RetryPolicy
+	// OperationTimeout bounds the time waited for the response to every
+	// operation, e.g. a slow search, which then fails with a network error.
+	// It does not apply to dialing.
+	OperationTimeout time.Duration
+	// DirectoryType, DirectoryOpenLDAP (default) or DirectoryActiveDirectory,
+	// selects the entries created for the directory, e.g. by CreateGroup.
+	// With Active Directory, users may log in as DOMAIN\user, user@domain or
+	// their sAMAccountName without UserFilter, and Authenticate binds with
+	// that logon name when no read only user is configured.
+	DirectoryType string
+	// UseMemberOf reads the groups returned by AuthenticateWithGroups from
+	// the memberOf attribute of the user, maintained by Active Directory and
+	// the OpenLDAP memberof overlay, saving a group search.
+	UseMemberOf bool
+	// CacheTTL caches the results of Filter, and so of GetGroupsOfUser, for
+	// that long, until the next write through the client. Results are cached
+	// per bound identity and request controls. The cache is created by New,
+	// Connect or NewPool, and shared by the copies of the client taken
+	// afterwards, such as pooled clients.
+	CacheTTL time.Duration
+	cache    *searchCache
+	// PasswordPolicyDN is the default OpenLDAP password policy, e.g.
+	// "cn=default,ou=policies,dc=example,dc=com", used by
+	// GetPasswordExpiration for the users without pwdPolicySubentry.
+	PasswordPolicyDN string
+	// IgnoreValueConflicts makes ChangeAttributeAdd succeed when a value is
+	// already present and ChangeAttributeDelete when a value is missing,
+	// which fail with attributeOrValueExists and noSuchAttribute otherwise,
+	// leaving the other values unchanged.
+	IgnoreValueConflicts bool
+	// DecodeObjectIDs makes GetAttributes, GetEntryByDN and FilterEntries
+	// return the Active Directory objectGUID and objectSid values in their
+	// string forms, see GUIDToString and SIDToString.
+	DecodeObjectIDs bool
+	// BindStrategy, BindEveryOp (default) or BindOnce, sets whether every
+	// operation binds the connection with the read only user again, or only
+	// when it is not known to be bound as them already, saving a round trip.
+	BindStrategy    string
+	boundAsBindUser bool
+	boundDN         string
+	// SearchBases, when set, replace Base for the searches of Authenticate,
+	// the Filter methods except FilterPage, and the searches of users and
+	// groups, e.g. to find users under both "ou=people,dc=example,dc=com"
+	// and "ou=contractors,dc=corp,dc=com". Users are searched for under each
+	// base in turn until found, and the entries found under several bases
+	// are returned once. The DNs built from templates still use Base.
+	SearchBases []string
+	// AmbiguousMatch, AmbiguousMatchError (default), AmbiguousMatchFirst or
+	// AmbiguousMatchMostSpecific, sets whether finding several entries for a
+	// user returns ErrTooManyEntries, the first one found, or the deepest one
+	// in the tree, and ErrTooManyEntries when several are as deep.
+	AmbiguousMatch string
+	// GroupAttributes are added to the groups created by CreateGroup,
+	// overriding the default ones, e.g. a description or another groupType.
+	GroupAttributes map[string][]string
+	// MoveGroupMemberships makes MoveUser and RenameGroup rewrite the member
+	// and uniqueMember values referencing the old DN of the user or group.
+	MoveGroupMemberships bool
+	// PageSize is the number of entries per page of the paged searches, e.g.
+	// FilterEach, 500 by default.
+	PageSize uint32
+	// TLSConfig, when set, is used for LDAPS and StartTLS connections, e.g.
+	// to set MinVersion, CipherSuites or RootCAs. ServerName, unless empty,
+	// and InsecureSkipVerify, if set, are applied to a copy of it.
+	TLSConfig *tls.Config
+	// StartTLSConfig replaces TLSConfig for StartTLS, and StartTLSInsecure
+	// skips the certificate verification of StartTLS only, e.g. to verify
+	// LDAPS strictly but not an internal StartTLS hop.
+	StartTLSConfig   *tls.Config
+	StartTLSInsecure bool
+	// MinTLSVersion, e.g. tls.VersionTLS12, is the minimum TLS version of
+	// both LDAPS and StartTLS, raising the one of TLSConfig if lower. Cipher
+	// suites are restricted with the CipherSuites of TLSConfig.
+	MinTLSVersion uint16
+	// Dialer, when set, opens the connections instead of the default
+	// dialer, e.g. a *net.Dialer with a LocalAddr or a SOCKS proxy dialer
+	// from golang.org/x/net/proxy. Ready uses its DialContext method, if any.
+	Dialer Dialer
+	// DetectLeaks logs the clients garbage collected without being closed,
+	// and closes their connection, to track down missing Close calls.
+	DetectLeaks bool
+	leak        *leakDetector
 }
 
+// Dialer opens network connections, like *net.Dialer and the dialers of
+// golang.org/x/net/proxy.
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
+// contextDialer is a Dialer that can give up with a context, like
+// *net.Dialer, used by Ready.
+type contextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// SearchOptions holds the search request parameters, see ldap.NewSearchRequest.
+type SearchOptions struct {
+	Scope        int // e.g. ldap.ScopeWholeSubtree
+	DerefAliases int // e.g. ldap.DerefInSearching
+	SizeLimit    int
+	TimeLimit    int  // in seconds
+	TypesOnly    bool // returns the attribute names without their values
+}
+
+// defaultSearchOptions are used when SearchOptions is nil.
+var defaultSearchOptions = SearchOptions{
+	Scope:        ldap.ScopeWholeSubtree,
+	DerefAliases: ldap.NeverDerefAliases,
+}
+
+var (
+	// ErrUserNotFound is returned when no entry matches the user filter.
+	ErrUserNotFound = errors.New("User does not exist")
+	// ErrTooManyEntries is returned when several entries match the user filter.
+	ErrTooManyEntries = errors.New("Too many entries returned")
+	// ErrUnauthenticatedBind is returned when binding with a DN and an empty
+	// password while AllowUnauthenticatedBind is not set.
+	ErrUnauthenticatedBind = errors.New("Unauthenticated bind with an empty password not allowed")
+	// ErrInvalidCredentials is returned when the server rejects the
+	// credentials of a bind, as opposed to connection errors.
+	ErrInvalidCredentials = errors.New("Invalid credentials")
+	// ErrNotLeaf is returned when deleting an entry that has subordinates,
+	// e.g. an OU that still holds users.
+	ErrNotLeaf = errors.New("Entry has subordinates")
+	// ErrEntryChanged is returned by ModifyIfUnchanged when the entry was
+	// modified since it was read.
+	ErrEntryChanged = errors.New("Entry changed since it was read")
+	// ErrAssertionFailed is returned when the filter of an assertion control
+	// does not match the target entry, so the operation was not performed.
+	ErrAssertionFailed = errors.New("Assertion failed")
+	// ErrStartTLSUnsupported is returned by StartTLS when the server does
+	// not advertise the StartTLS extended operation.
+	ErrStartTLSUnsupported = errors.New("StartTLS not supported by the server")
+	// ErrUnreachable is wrapped by the errors of Ready when the directory
+	// cannot be connected to, as opposed to ErrInvalidCredentials.
+	ErrUnreachable = errors.New("Directory unreachable")
+
+	errEntryNotFound = errors.New("Entry does not exist")
+)
+
+// ObserveFunc receives the name of an operation ("connect", "startTLS",
+// "bind", "search", "add", "modify", "modifyDN", "delete" or
+// "passwordModify"), its duration and its error, if any.
+type ObserveFunc func(op string, duration time.Duration, err error)
+
+// Logger is the interface used to trace operations, satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Attribute selectors with a special meaning in searches.
+const (
+	// AllUserAttributes selects all user attributes, the default.
+	AllUserAttributes = "*"
+	// AllOperationalAttributes selects all operational attributes, e.g.
+	// createTimestamp or pwdChangedTime, which are only returned on demand.
+	// Active Directory does not support it, so list them explicitly there.
+	AllOperationalAttributes = "+"
+	// NoAttributes selects no attribute, returning only the DNs.
+	NoAttributes = "1.1"
+)
+
+// matchingRuleInChain is the Active Directory LDAP_MATCHING_RULE_IN_CHAIN OID.
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+// firstGIDNumber is the gidNumber given to the first group created by
+// CreateGroup when the directory holds no posixGroup yet.
+const firstGIDNumber = 10000
+
+// controlTypeProxiedAuthz is the RFC 4370 proxied authorization control OID.
+const controlTypeProxiedAuthz = "2.16.840.1.113730.3.4.18"
+
+// extensionStartTLS is the StartTLS extended operation OID.
+const extensionStartTLS = "1.3.6.1.4.1.1466.20037"
+
+// controlTypeAssertion is the RFC 4528 assertion control OID.
+const controlTypeAssertion = "1.3.6.1.1.12"
+
+// controlTypeSubtreeDelete is the Active Directory LDAP_SERVER_TREE_DELETE_OID
+// control, also supported by OpenLDAP.
+const controlTypeSubtreeDelete = "1.2.840.113556.1.4.805"
+
+// Ambiguous match policies, see LDAPClient.AmbiguousMatch.
+const (
+	AmbiguousMatchError        = "error"
+	AmbiguousMatchFirst        = "first"
+	AmbiguousMatchMostSpecific = "mostSpecific"
+)
+
+// Bind strategies, see LDAPClient.BindStrategy.
+const (
+	BindEveryOp = "everyOp"
+	BindOnce    = "once"
+)
+
+// defaultPageSize is used when PageSize is not set.
+const defaultPageSize = 500
+
+// defaultDNTemplate is used when UserDNTemplate or GroupDNTemplate is empty.
+const defaultDNTemplate = "cn=%s,ou=%s,%s"
+
 type AddUserAccount struct {
 	Username string
 	Password string
 	OU       string
 	UID      int
 	GID      int
+	// ObjectClasses are added to the inetOrgPerson and posixAccount ones,
+	// e.g. "shadowAccount" or a site specific auxiliary class.
+	ObjectClasses []string
+	// ShadowLastChange and ShadowMax set the password aging attributes when
+	// not zero, adding the shadowAccount objectClass.
+	ShadowLastChange int
+	ShadowMax        int
+	// HomeDirectory and LoginShell default to "/home/<username>" and
+	// "/bin/bash".
+	HomeDirectory string
+	LoginShell    string
+	// Extra attributes, e.g. gecos or mail, are set along, replacing the
+	// default values of the attributes they share, objectClass included.
+	Extra map[string][]string
+}
+
+// Group is a group entry with its common attributes.
+type Group struct {
+	DN          string
+	CN          string
+	Description string
+}
+
+// defaultUserAccountObjectClasses are always set by AddUserAccount.
+var defaultUserAccountObjectClasses = []string{"inetOrgPerson", "posixAccount"}
+
+// Connect connects to the ldap backend. With several Hosts, they are tried
+// in order starting from the last one that worked.
+//
+// UseSSL connects with LDAPS, otherwise the connection is upgraded with
+// StartTLS unless SkipTLS is set, and UseSSL and SkipTLS cannot be combined.
+// Either way the server certificate is verified against ServerName, which
+// defaults to the host, unless InsecureSkipVerify is set.
+func (lc *LDAPClient) Connect() error {
+	// Create the cache before the client is copied, to share it
+	lc.searchCache()
+	return lc.retry(true, lc.connect)
+}
+
+// connect tries every host once, unless already connected.
+func (lc *LDAPClient) connect() error {
+	if lc.Conn == nil {
+		err := lc.applyURL()
+		if err != nil {
+			return err
+		}
+
+		err = lc.validate()
+		if err != nil {
+			return err
+		}
+
+		hosts := lc.hosts()
+		for i := range hosts {
+			index := (lc.hostIndex + i) % len(hosts)
+			address := lc.address(hosts[index])
+			start := time.Now()
+			var l *ldap.Conn
+			l, err = lc.dial(hosts[index])
+			lc.trace("connect", address, start, err)
+			if err == nil {
+				lc.Conn = l
+				lc.boundAsBindUser = false
+				lc.boundDN = ""
+				lc.hostIndex = index
+				lc.detectLeak(address)
+				return nil
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// applyURL derives Host, Port, UseSSL and ServerName from URL, if set.
+func (lc *LDAPClient) applyURL() error {
+	if lc.URL == "" {
+		return nil
+	}
+
+	host, port, useSSL, _, err := parseURL(lc.URL)
+	if err != nil {
+		return err
+	}
+
+	lc.Host, lc.Port, lc.UseSSL = host, port, useSSL
+	if lc.ServerName == "" {
+		lc.ServerName = host
+	}
+	return nil
+}
+
+// parseURL splits an ldap:// or ldaps:// URL, e.g. a referral, defaulting
+// the port to 389 or 636.
+func parseURL(rawurl string) (host string, port int, useSSL bool, base string, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", 0, false, "", err
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "ldap":
+		port = 389
+	case "ldaps":
+		port, useSSL = 636, true
+	default:
+		return "", 0, false, "", errors.New("Unsupported URL " + rawurl)
+	}
+
+	host = u.Host
+	if h, p, err := net.SplitHostPort(u.Host); err == nil {
+		host = h
+		port, err = strconv.Atoi(p)
+		if err != nil {
+			return "", 0, false, "", err
+		}
+	}
+	return host, port, useSSL, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// hosts returns the hosts to connect to.
+func (lc *LDAPClient) hosts() []string {
+	if lc.URL == "" && len(lc.Hosts) > 0 {
+		return lc.Hosts
+	}
+	return []string{lc.Host}
+}
+
+// address returns the host:port to dial, bracketing IPv6 literals.
+func (lc *LDAPClient) address(host string) string {
+	return net.JoinHostPort(host, strconv.Itoa(lc.port()))
+}
+
+// port returns Port, defaulting to 636 with UseSSL and to 389 otherwise.
+func (lc *LDAPClient) port() int {
+	if lc.Port != 0 {
+		return lc.Port
+	}
+	if lc.UseSSL {
+		return 636
+	}
+	return 389
+}
+
+// reconnect opens a new connection bound with the read only user, if any,
+// when there is none, e.g. after Close or a network error, and keeps the
+// current connection and its bind otherwise.
+func (lc *LDAPClient) reconnect() error {
+	if lc.Conn != nil {
+		return nil
+	}
+	return lc.connectAdmin()
+}
+
+// redial is reconnect for the attempts of an operation that is already
+// retried, so it tries every host only once instead of retrying Connect.
+func (lc *LDAPClient) redial() error {
+	if lc.Conn != nil {
+		return nil
+	}
+	err := lc.connect()
+	if err != nil {
+		return err
+	}
+	return lc.Rebind()
+}
+
+// failover drops a connection that failed with a network error, so that the
+// next Connect starts with the next host.
+func (lc *LDAPClient) failover(err error) {
+	if err != nil && ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		lc.Close()
+		lc.hostIndex++
+	}
+}
+
+// validate rejects contradictory connection settings.
+func (lc *LDAPClient) validate() error {
+	if lc.UseSSL && lc.SkipTLS {
+		return errors.New("UseSSL and SkipTLS cannot be combined")
+	}
+	if lc.Port < 0 || lc.Port > 65535 {
+		return fmt.Errorf("Invalid port %d", lc.Port)
+	}
+	return nil
+}
+
+// dial opens a new connection to host, using SSL or StartTLS as configured.
+func (lc *LDAPClient) dial(host string) (*ldap.Conn, error) {
+	return lc.dialContext(context.Background(), host)
+}
+
+// dialContext opens a new connection to host like dial, giving up when ctx
+// is done first.
+func (lc *LDAPClient) dialContext(ctx context.Context, host string) (*ldap.Conn, error) {
+	address := lc.address(host)
+	var l *ldap.Conn
+	var err error
+	switch {
+	case lc.Dialer != nil, ctx.Done() != nil:
+		l, err = lc.dialWith(ctx, host, address)
+	case lc.UseSSL:
+		l, err = ldap.DialURL("ldaps://"+address, ldap.DialWithTLSConfig(lc.tlsConfig(host)))
+	default:
+		l, err = ldap.DialURL("ldap://" + address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lc.setTimeout(l)
+
+	// Abort StartTLS when the context is done first
+	stop := context.AfterFunc(ctx, func() {
+		l.Close()
+	})
+	defer stop()
+
+	// Reconnect with TLS
+	if !lc.UseSSL && !lc.SkipTLS {
+		err = l.StartTLS(lc.startTLSConfig(host))
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if ctx.Err() != nil {
+		l.Close()
+		return nil, ldap.NewError(ldap.ErrorNetwork, ctx.Err())
+	}
+	atomic.AddInt64(&openConnections, 1)
+	return l, nil
+}
+
+// dialWith opens a new connection to address with Dialer, or a net.Dialer
+// when not set, and the SSL handshake with UseSSL, giving up when ctx is
+// done first, if the dialer supports it.
+func (lc *LDAPClient) dialWith(ctx context.Context, host, address string) (*ldap.Conn, error) {
+	var conn net.Conn
+	var err error
+	contextual, ok := lc.Dialer.(contextDialer)
+	switch {
+	case lc.Dialer == nil:
+		conn, err = (&net.Dialer{Timeout: ldap.DefaultTimeout}).DialContext(ctx, "tcp", address)
+	case ok && ctx.Done() != nil:
+		conn, err = contextual.DialContext(ctx, "tcp", address)
+	default:
+		conn, err = lc.Dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, ldap.NewError(ldap.ErrorNetwork, err)
+	}
+
+	if lc.UseSSL {
+		tlsConn := tls.Client(conn, lc.tlsConfig(host))
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			conn.Close()
+			return nil, ldap.NewError(ldap.ErrorNetwork, err)
+		}
+		conn = tlsConn
+	}
+
+	l := ldap.NewConn(conn, lc.UseSSL)
+	l.Start()
+	return l, nil
+}
+
+// setTimeout applies the OperationTimeout, if any, to a new connection.
+func (lc *LDAPClient) setTimeout(l *ldap.Conn) {
+	if lc.OperationTimeout > 0 {
+		l.SetTimeout(lc.OperationTimeout)
+	}
+}
+
+// StartTLS upgrades a plain connection, e.g. one opened by Connect with
+// SkipTLS, to TLS, verifying the certificate like Connect. It returns
+// ErrStartTLSUnsupported when the server does not advertise StartTLS, and
+// does nothing when the connection already uses TLS.
+func (lc *LDAPClient) StartTLS() error {
+	err := lc.Connect()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := lc.Conn.TLSConnectionState(); ok {
+		return nil
+	}
+
+	supported, err := lc.rootDSEAdvertises("supportedExtension", extensionStartTLS)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return ErrStartTLSUnsupported
+	}
+
+	host := lc.currentHost()
+	start := time.Now()
+	err = lc.Conn.StartTLS(lc.startTLSConfig(host))
+	lc.trace("startTLS", lc.address(host), start, err)
+	lc.failover(err)
+	return err
+}
+
+// tlsConfig returns the TLS configuration used to connect to host.
+func (lc *LDAPClient) tlsConfig(host string) *tls.Config {
+	return lc.buildTLSConfig(lc.TLSConfig, lc.InsecureSkipVerify, host)
+}
+
+// startTLSConfig returns the TLS configuration of StartTLS, which may verify
+// the certificate differently than LDAPS.
+func (lc *LDAPClient) startTLSConfig(host string) *tls.Config {
+	base := lc.StartTLSConfig
+	if base == nil {
+		base = lc.TLSConfig
+	}
+	return lc.buildTLSConfig(base, lc.InsecureSkipVerify || lc.StartTLSInsecure, host)
+}
+
+// buildTLSConfig returns a copy of base, if any, verifying the certificate
+// against ServerName or host unless insecure.
+func (lc *LDAPClient) buildTLSConfig(base *tls.Config, insecure bool, host string) *tls.Config {
+	serverName := lc.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	config := &tls.Config{}
+	if base != nil {
+		config = base.Clone()
+	}
+	if lc.ServerName != "" || config.ServerName == "" {
+		config.ServerName = serverName
+	}
+	if insecure {
+		config.InsecureSkipVerify = true
+	}
+	if lc.MinTLSVersion > config.MinVersion {
+		config.MinVersion = lc.MinTLSVersion
+	}
+	return config
+}
+
+// Close closes the ldap backend connection.
+func (lc *LDAPClient) Close() {
+	if lc.Conn != nil {
+		closeConn(lc.Conn)
+		lc.Conn = nil
+		lc.boundAsBindUser = false
+		lc.boundDN = ""
+	}
+	if lc.leak != nil {
+		lc.leak.conn = nil
+		lc.leak = nil
+	}
+}
+
+// Authenticate authenticates the user against the ldap backend.
+// The returned map holds the configured attributes and the DN of the user
+// under the "dn" key. An empty password is rejected with
+// ErrInvalidCredentials before binding, even with AllowUnauthenticatedBind.
+func (lc *LDAPClient) Authenticate(username, password string) (bool, map[string]string, error) {
+	return lc.AuthenticateWithAttrs(username, password, lc.Attributes)
+}
+
+// AuthenticateWithAttrs authenticates the user like Authenticate, returning
+// the given attributes instead of the configured ones.
+func (lc *LDAPClient) AuthenticateWithAttrs(username, password string, attributes []string) (bool, map[string]string, error) {
+	ok, user, _, err := lc.authenticate(username, password, attributes, false)
+	return ok, user, err
+}
+
+// AuthenticateWithGroups authenticates the user like Authenticate, also
+// returning the DNs of their groups. With UseMemberOf, they are read from the
+// memberOf attribute of the user in the same search, otherwise they are
+// searched for with GroupFilter afterwards.
+func (lc *LDAPClient) AuthenticateWithGroups(username, password string) (bool, map[string]string, []string, error) {
+	if lc.UseMemberOf {
+		return lc.authenticate(username, password, lc.Attributes, true)
+	}
+
+	ok, user, err := lc.Authenticate(username, password)
+	if !ok || err != nil {
+		return ok, user, nil, err
+	}
+
+	entries, err := lc.searchEntries(lc.groupFilter(username), []string{"dn"})
+	if err != nil {
+		return true, user, nil, err
+	}
+	groups := []string{}
+	for _, entry := range entries {
+		groups = append(groups, entry.DN)
+	}
+	return true, user, groups, nil
+}
+
+// authenticate authenticates the user, returning the given attributes, and
+// the values of their memberOf attribute when requested.
+func (lc *LDAPClient) authenticate(username, password string, attributes []string, memberOf bool) (bool, map[string]string, []string, error) {
+	// Servers treat a bind without password as anonymous, which succeeds
+	if password == "" {
+		return false, nil, nil, ErrInvalidCredentials
+	}
+
+	err := lc.Connect()
+	if err != nil {
+		return false, nil, nil, err
+	}
+
+	// Without a read only user, bind as the user straight away
+	if lc.bindsDirectly() {
+		return lc.authenticateDirect(username, password, attributes, memberOf)
+	}
+
+	// First bind with a read only user
+	err = lc.Rebind()
+	if err != nil {
+		return false, nil, nil, err
+	}
+
+	// Search for the given username
+	entry, err := lc.findUser(username, userAttributes(attributes, memberOf, "dn"))
+	if err != nil {
+		return false, nil, nil, err
+	}
+
+	userDN := entry.DN
+	user := map[string]string{}
+	for _, attr := range attributes {
+		user[attr] = entry.GetAttributeValue(attr)
+	}
+	user["dn"] = userDN
+	var groups []string
+	if memberOf {
+		groups = entry.GetAttributeValues("memberOf")
+	}
+
+	// Verify the password without binding the shared connection as the user,
+	// failing with the same error as a bind on it
+	if lc.VerifyOnSeparateConnection {
+		err = lc.verifyPassword(userDN, password)
+		if err != nil {
+			return false, user, nil, err
+		}
+		return true, user, groups, nil
+	}
+
+	// Bind as the user to verify their password
+	err = lc.bindUser(username, userDN, password)
+	if err != nil {
+		return false, user, nil, err
+	}
+
+	err = lc.unbindUser()
+	if err != nil {
+		return true, user, groups, err
+	}
+
+	return true, user, groups, nil
+}
+
+// userAttributes returns the attributes to search a user for, with memberOf
+// when requested.
+func userAttributes(attributes []string, memberOf bool, extra ...string) []string {
+	// Never append to the slice of the caller, which may be shared
+	searchAttributes := append(append([]string{}, attributes...), extra...)
+	if memberOf {
+		searchAttributes = append(searchAttributes, "memberOf")
+	}
+	return searchAttributes
+}
+
+// authenticateDirect binds as the user with the DN or user principal name
+// built from BindUserDNTemplate, or the Active Directory logon name, then
+// reads their attributes, and memberOf when requested, with the same bind.
+func (lc *LDAPClient) authenticateDirect(username, password string, attributes []string, memberOf bool) (bool, map[string]string, []string, error) {
+	bindName := lc.bindUserName(username)
+	err := lc.bindUser(username, bindName, password)
+	if err != nil {
+		return false, nil, nil, err
+	}
+
+	searchAttributes := userAttributes(attributes, memberOf)
+	var entry *ldap.Entry
+	switch {
+	case lc.BindUserDNTemplate == "":
+		entry, err = lc.findUser(username, searchAttributes)
+	case isDNTemplate(lc.BindUserDNTemplate):
+		entry, err = lc.readEntry(bindName, searchAttributes)
+		if err == errEntryNotFound {
+			err = ErrUserNotFound
+		}
+	default:
+		entry, err = lc.findUPN(bindName, searchAttributes)
+	}
+	if err != nil {
+		if lc.Conn != nil {
+			lc.unbindUser()
+		}
+		return true, nil, nil, err
+	}
+
+	user := map[string]string{}
+	for _, attr := range attributes {
+		user[attr] = entry.GetAttributeValue(attr)
+	}
+	user["dn"] = entry.DN
+	var groups []string
+	if memberOf {
+		groups = entry.GetAttributeValues("memberOf")
+	}
+
+	err = lc.unbindUser()
+	if err != nil {
+		return true, user, groups, err
+	}
+
+	return true, user, groups, nil
+}
+
+// bindsDirectly reports whether users are bound as straight away, with the
+// name built by bindUserName, as no read only user can look them up.
+func (lc *LDAPClient) bindsDirectly() bool {
+	return (lc.BindUserDNTemplate != "" || lc.DirectoryType == DirectoryActiveDirectory) && !lc.hasBindUser()
+}
+
+// bindUserName returns the bind name of a user from BindUserDNTemplate, with
+// the username escaped in a DN, or else their Active Directory logon name.
+func (lc *LDAPClient) bindUserName(username string) string {
+	if lc.BindUserDNTemplate == "" {
+		return lc.adLogonName(username)
+	}
+	if isDNTemplate(lc.BindUserDNTemplate) {
+		return fmt.Sprintf(lc.BindUserDNTemplate, EscapeDN(username))
+	}
+	return fmt.Sprintf(lc.BindUserDNTemplate, username)
+}
+
+// isDNTemplate reports whether a bind template yields a DN rather than a user
+// principal name.
+func isDNTemplate(template string) bool {
+	return strings.Contains(template, "=")
+}
+
+// findUPN searches for the single user with the given user principal name.
+func (lc *LDAPClient) findUPN(upn string, attributes []string) (*ldap.Entry, error) {
+	entries, err := lc.searchEntries(fmt.Sprintf("(userPrincipalName=%s)", ldap.EscapeFilter(upn)), attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) < 1 {
+		return nil, ErrUserNotFound
+	}
+
+	if len(entries) > 1 {
+		return nil, ErrTooManyEntries
+	}
+
+	return entries[0], nil
+}
+
+// unbindUser rebinds as the read only user for any further queries, or
+// anonymously so that the connection is not left bound as the user.
+func (lc *LDAPClient) unbindUser() error {
+	if lc.hasBindUser() {
+		return lc.Rebind()
+	}
+	return lc.bind("", "")
 }
 
-// Connect connects to the ldap backend.
-func (lc *LDAPClient) Connect() error {
-	if lc.Conn == nil {
-		var l *ldap.Conn
-		var err error
-		address := fmt.Sprintf("%s:%d", lc.Host, lc.Port)
-		if !lc.UseSSL {
-			l, err = ldap.Dial("tcp", address)
-			if err != nil {
-				return err
-			}
+// ChangeOwnPassword changes the password of a user after verifying the old
+// one by binding as the user, with the password modify extended operation.
+// It returns ErrInvalidCredentials when the old password is wrong, and a
+// PasswordPolicyError when the new one breaks the policy of the server.
+func (lc *LDAPClient) ChangeOwnPassword(username, oldPassword, newPassword string) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	entry, err := lc.findUser(username, []string{"dn"})
+	if err != nil {
+		return err
+	}
+
+	err = lc.bind(entry.DN, oldPassword)
+	if accountLocked(err) {
+		return ErrAccountLocked
+	}
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+
+	// Change the password of the bound user
+	passwordModifyRequest := ldap.NewPasswordModifyRequest("", oldPassword, newPassword)
+	err = passwordPolicyError(lc.passwordModify(passwordModifyRequest, ldap.NewControlBeheraPasswordPolicy()))
+	if lc.Conn == nil {
+		return err
+	}
+
+	unbindErr := lc.unbindUser()
+	if err != nil {
+		return err
+	}
+	return unbindErr
+}
+
+// VerifyPassword checks the password of a user by binding as the user on a
+// dedicated connection, closed right after, so that the shared connection
+// stays bound as the read only user. It returns false when the server
+// rejects the credentials, ErrInvalidCredentials without connecting for an
+// empty password, and an error for any other failure.
+func (lc *LDAPClient) VerifyPassword(userDN, password string) (bool, error) {
+	// Servers treat a bind without password as anonymous, which succeeds
+	if password == "" {
+		return false, ErrInvalidCredentials
+	}
+
+	err := lc.verifyPassword(userDN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verifyPassword binds as the user on a dedicated connection, closed right
+// after, returning the error of the bind.
+func (lc *LDAPClient) verifyPassword(userDN, password string) error {
+	conn, err := lc.dialSeparate()
+	if err != nil {
+		return err
+	}
+	defer closeConn(conn)
+
+	err = lc.bindConn(conn, userDN, password)
+	if accountLocked(err) {
+		return ErrAccountLocked
+	}
+	return err
+}
+
+// ComparePassword checks the password of a user with a compare operation on
+// their userPassword, without binding, so that the bind of the connection is
+// left as is. The servers that refuse the comparison, as many do, are asked
+// with VerifyPassword on a dedicated connection instead, and so are the ones
+// that find no match, as a hashed userPassword, e.g. {SSHA}, only matches
+// the password when the server hashes it before comparing. An empty password
+// is rejected with ErrInvalidCredentials without connecting.
+func (lc *LDAPClient) ComparePassword(userDN, password string) (bool, error) {
+	// Rejected like by the other checks, as the bind fallback would succeed
+	if password == "" {
+		return false, ErrInvalidCredentials
+	}
+
+	err := lc.reconnect()
+	if err != nil {
+		return false, err
+	}
+
+	matched, err := lc.compare(userDN, "userPassword", password)
+	switch {
+	case err == nil && matched:
+		return true, nil
+	case err == nil,
+		ldap.IsErrorWithCode(err, ldap.LDAPResultInsufficientAccessRights),
+		ldap.IsErrorWithCode(err, ldap.LDAPResultInappropriateMatching),
+		ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform),
+		ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute):
+		return lc.VerifyPassword(userDN, password)
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject):
+		return false, ErrUserNotFound
+	default:
+		return false, err
+	}
+}
+
+// VerifyCredentials checks the password of a user like VerifyPassword, on a
+// dedicated connection closed right after, looking the user up with the read
+// only user, or without one binding like Authenticate with the name built
+// from BindUserDNTemplate, or the Active Directory logon name. It
+// returns false when the user is not found or the server rejects the
+// credentials, ErrInvalidCredentials without connecting for an empty
+// password, and an error for any other failure.
+func (lc *LDAPClient) VerifyCredentials(username, password string) (bool, error) {
+	if password == "" {
+		return false, ErrInvalidCredentials
+	}
+
+	conn, err := lc.dialSeparate()
+	if err != nil {
+		return false, err
+	}
+
+	// Work on a copy so that the shared connection is never used, nor its bind
+	separate := *lc
+	separate.Conn = conn
+	separate.leak = nil
+	separate.boundAsBindUser = false
+	separate.boundDN = ""
+	defer separate.Close()
+
+	userDN := ""
+	if lc.bindsDirectly() {
+		userDN = lc.bindUserName(username)
+	} else {
+		err = separate.Rebind()
+		if err != nil {
+			return false, err
+		}
+
+		entry, err := separate.findUser(username, []string{"dn"})
+		if err == ErrUserNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		userDN = entry.DN
+	}
+
+	err = separate.bindUser(username, userDN, password)
+	if err == ErrAccountLocked {
+		return false, err
+	}
+	if err == ErrInvalidCredentials || ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Ready checks that the directory is reachable and, when configured, that
+// the read only user can bind, by reading the root DSE on a dedicated
+// connection, e.g. for a readiness probe. The errors wrap ErrUnreachable when
+// connecting fails or the context is done first, and are
+// ErrInvalidCredentials when the read only user is rejected. The check is
+// abandoned and its connection closed as soon as the context is done.
+func (lc *LDAPClient) Ready(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- lc.checkReady(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", ErrUnreachable, ctx.Err())
+	}
+}
+
+// checkReady connects, binds and reads the root DSE for Ready, closing the
+// connection as soon as ctx is done.
+func (lc *LDAPClient) checkReady(ctx context.Context) error {
+	conn, err := lc.dialSeparateContext(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer closeConn(conn)
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetTimeout(time.Until(deadline))
+	}
+
+	if lc.BindDN != "" && lc.BindPassword != "" {
+		err = lc.bindConn(conn, lc.BindDN, lc.BindPassword)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return ErrInvalidCredentials
+		}
+		if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+			return fmt.Errorf("%w: %v", ErrUnreachable, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		"",
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		[]string{NoAttributes},
+		nil,
+	)
+	start := time.Now()
+	_, err = conn.Search(searchRequest)
+	lc.trace("search", `base="" filter="(objectClass=*)"`, start, err)
+	if ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	return err
+}
+
+// dialSeparate opens a new connection to the current host, independent from
+// the shared one.
+func (lc *LDAPClient) dialSeparate() (*ldap.Conn, error) {
+	return lc.dialSeparateContext(context.Background())
+}
+
+// dialSeparateContext opens a new connection like dialSeparate, giving up
+// when ctx is done first.
+func (lc *LDAPClient) dialSeparateContext(ctx context.Context) (*ldap.Conn, error) {
+	err := lc.applyURL()
+	if err != nil {
+		return nil, err
+	}
+
+	err = lc.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	host := lc.currentHost()
+	start := time.Now()
+	conn, err := lc.dialContext(ctx, host)
+	lc.trace("connect", lc.address(host), start, err)
+	return conn, err
+}
+
+// Rebind binds the connection back with BindDN and BindPassword, or with
+// the Kerberos credentials for GSSAPI, e.g. to recover when Authenticate
+// failed to restore the read only user after binding as the user.
+// It does nothing when no bind user is configured, or with BindOnce when the
+// connection is bound with the read only user already.
+func (lc *LDAPClient) Rebind() error {
+	if !lc.hasBindUser() {
+		return nil
+	}
+
+	err := lc.Connect()
+	if err != nil {
+		return err
+	}
+
+	if lc.BindStrategy == BindOnce && lc.boundAsBindUser {
+		return nil
+	}
+
+	switch lc.AuthMethod {
+	case AuthDigestMD5, AuthGSSAPI:
+		err = lc.saslBind(lc.AuthMethod, lc.BindDN, lc.BindPassword)
+	case AuthNTLM:
+		err = lc.ntlmBind(lc.Domain, lc.BindDN, lc.BindPassword)
+	default:
+		err = lc.bind(lc.BindDN, lc.BindPassword)
+	}
+	lc.boundAsBindUser = err == nil
+	return err
+}
+
+// bindUser binds as the user to verify their password, with the configured
+// authentication method.
+func (lc *LDAPClient) bindUser(username, userDN, password string) error {
+	var err error
+	switch lc.AuthMethod {
+	case AuthDigestMD5:
+		err = lc.saslBind(AuthDigestMD5, username, password)
+	case AuthNTLM:
+		err = lc.ntlmBind(lc.Domain, username, password)
+	default:
+		err = lc.bind(userDN, password)
+	}
+	if accountLocked(err) {
+		return ErrAccountLocked
+	}
+	return err
+}
+
+// hasBindUser reports whether a read only user is configured.
+func (lc *LDAPClient) hasBindUser() bool {
+	return lc.AuthMethod == AuthGSSAPI || lc.BindDN != "" && lc.BindPassword != ""
+}
+
+// BindAnonymous binds the connection anonymously, e.g. to read the root DSE
+// or public attributes.
+func (lc *LDAPClient) BindAnonymous() error {
+	err := lc.Connect()
+	if err != nil {
+		return err
+	}
+
+	return lc.bind("", "")
+}
+
+// GetUser returns the entry of a user with the given attributes.
+func (lc *LDAPClient) GetUser(username string, attributes []string) (*ldap.Entry, error) {
+	err := lc.connectAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	return lc.findUser(username, attributes)
+}
+
+// GetUsersInOU returns the users of an OU under the base, and of its sub OUs,
+// with the given attributes, paging through large OUs.
+func (lc *LDAPClient) GetUsersInOU(ou string, attributes []string) ([]*ldap.Entry, error) {
+	err := lc.connectAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	// Active Directory computers are persons too
+	filter := "(objectClass=person)"
+	if lc.DirectoryType == DirectoryActiveDirectory {
+		filter = "(&(objectCategory=person)(objectClass=user))"
+	}
+
+	entries := []*ldap.Entry{}
+	err = lc.searchPages(lc.ouDN(ou), filter, attributes, lc.searchOptions(), func(entry *ldap.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetUserDN returns the DN of the single entry matching the user filter, or
+// ErrUserNotFound or ErrTooManyEntries.
+func (lc *LDAPClient) GetUserDN(username string) (string, error) {
+	entry, err := lc.GetUser(username, []string{"dn"})
+	if err != nil {
+		return "", err
+	}
+	return entry.DN, nil
+}
+
+// UserExists reports whether an entry matches the user filter under the base,
+// without reading its attributes. Errors, e.g. network ones, are returned
+// instead of false.
+func (lc *LDAPClient) UserExists(username string) (bool, error) {
+	err := lc.connectAdmin()
+	if err != nil {
+		return false, err
+	}
+
+	options := lc.searchOptions()
+	options.TypesOnly = true
+	entries, err := lc.searchAll(lc.userFilter(username), []string{NoAttributes}, options)
+	if err != nil {
+		return false, err
+	}
+	return len(entries) > 0, nil
+}
+
+// findUser searches for the single entry matching the user filter, under
+// each of the search bases until found.
+func (lc *LDAPClient) findUser(username string, attributes []string) (*ldap.Entry, error) {
+	for _, base := range lc.searchBases() {
+		entries, err := lc.searchBase(base, lc.userFilter(username), attributes, lc.searchOptions())
+		if err != nil {
+			return nil, err
+		}
+
+		if len(entries) < 1 {
+			continue
+		}
+
+		return lc.selectUser(entries)
+	}
+	return nil, ErrUserNotFound
+}
+
+// selectUser returns the single entry found for a user, or the one chosen by
+// AmbiguousMatch among several.
+func (lc *LDAPClient) selectUser(entries []*ldap.Entry) (*ldap.Entry, error) {
+	if len(entries) == 1 {
+		return entries[0], nil
+	}
+
+	switch lc.AmbiguousMatch {
+	case AmbiguousMatchFirst:
+		return entries[0], nil
+	case AmbiguousMatchMostSpecific:
+		var deepest *ldap.Entry
+		depth, tie := -1, false
+		for _, entry := range entries {
+			dn, err := ldap.ParseDN(entry.DN)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case len(dn.RDNs) > depth:
+				deepest, depth, tie = entry, len(dn.RDNs), false
+			case len(dn.RDNs) == depth:
+				tie = true
+			}
+		}
+		if !tie {
+			return deepest, nil
+		}
+	}
+	return nil, ErrTooManyEntries
+}
+
+// GetGroupsOfUser returns the group for a user.
+func (lc *LDAPClient) GetGroupsOfUser(username string) ([]string, error) {
+	return lc.Filter(lc.groupFilter(username), []string{"cn"})
+}
+
+// GetGroupsOfUserAttrs returns the entries of the groups for a user, with
+// the given attributes, e.g. gidNumber or description.
+func (lc *LDAPClient) GetGroupsOfUserAttrs(username string, attributes []string) ([]*ldap.Entry, error) {
+	return lc.searchEntries(lc.groupFilter(username), attributes)
+}
+
+// GetUserGroups returns the groups for a user like GetGroupsOfUser, along
+// with their DN and description.
+func (lc *LDAPClient) GetUserGroups(username string) ([]Group, error) {
+	entries, err := lc.searchEntries(lc.groupFilter(username), []string{"cn", "description"})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []Group{}
+	for _, entry := range entries {
+		groups = append(groups, Group{
+			DN:          entry.DN,
+			CN:          entry.GetAttributeValue("cn"),
+			Description: entry.GetAttributeValue("description"),
+		})
+	}
+	return groups, nil
+}
+
+// GetGroupsOfUserRecursive returns the groups for a user, including the groups
+// inherited through nested group membership. Groups are told apart by DN, so
+// that groups sharing a cn in different OUs are each returned.
+func (lc *LDAPClient) GetGroupsOfUserRecursive(username string) ([]string, error) {
+	if lc.UseMatchingRuleInChain {
+		return lc.getGroupsOfUserInChain(username)
+	}
+
+	entries, err := lc.searchEntries(lc.groupFilter(username), []string{"cn"})
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk up the hierarchy following member/uniqueMember links, visiting
+	// every group DN once so that membership cycles terminate.
+	visited := map[string]bool{}
+	var found []*ldap.Entry
+	for len(entries) > 0 {
+		var parents []*ldap.Entry
+		for _, entry := range entries {
+			key := strings.ToLower(entry.DN)
+			if visited[key] {
+				continue
+			}
+			visited[key] = true
+			found = append(found, entry)
+
+			dn := ldap.EscapeFilter(entry.DN)
+			groupParents, err := lc.searchEntries(fmt.Sprintf("(|(member=%s)(uniqueMember=%s))", dn, dn), []string{"cn"})
+			if err != nil {
+				return nil, err
+			}
+			parents = append(parents, groupParents...)
+		}
+		entries = parents
+	}
+
+	groups := []string{}
+	for _, entry := range found {
+		groups = append(groups, entry.GetAttributeValues("cn")...)
+	}
+	return groups, nil
+}
+
+// getGroupsOfUserInChain lets an Active Directory server resolve the nested
+// groups of a user in a single search.
+func (lc *LDAPClient) getGroupsOfUserInChain(username string) ([]string, error) {
+	user, err := lc.findUser(username, []string{"dn"})
+	if err != nil {
+		return nil, err
+	}
+
+	filter := fmt.Sprintf("(member:%s:=%s)", matchingRuleInChain, ldap.EscapeFilter(user.DN))
+	return lc.Filter(filter, []string{"cn"})
+}
+
+// GetGroupMembers returns the members of a given group.
+func (lc *LDAPClient) GetGroupMembers(groupname, ou string) ([]string, error) {
+	err := lc.connectAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	attribute := lc.groupMemberAttribute()
+	group, err := lc.readEntry(lc.groupDN(groupname, ou), []string{attribute})
+	if err != nil {
+		return nil, err
+	}
+
+	members := group.GetAttributeValues(attribute)
+	if attribute == "memberUid" || !lc.ResolveMemberDNs {
+		return members, nil
+	}
+
+	uids := make([]string, 0, len(members))
+	for _, member := range members {
+		entry, err := lc.readEntry(member, []string{"uid"})
+		if err != nil {
+			return nil, err
+		}
+		uids = append(uids, entry.GetAttributeValue("uid"))
+	}
+	return uids, nil
+}
+
+// groupMemberAttribute returns the attribute holding the members of a group.
+func (lc *LDAPClient) groupMemberAttribute() string {
+	if lc.GroupMemberAttribute == "" {
+		return "memberUid"
+	}
+	return lc.GroupMemberAttribute
+}
+
+// GetAllGroups returns the group for a user.
+func (lc *LDAPClient) GetAllGroups() ([]string, error) {
+	filter := "(objectClass=posixGroup)"
+	return lc.Filter(filter, []string{"cn"})
+}
+
+// GetAllGroupMembers returns the members of every group under the search
+// bases, by group cn, as stored in GroupMemberAttribute, without resolving
+// DNs. See EachGroupMembers for large directories.
+func (lc *LDAPClient) GetAllGroupMembers() (map[string][]string, error) {
+	groups := map[string][]string{}
+	err := lc.EachGroupMembers(func(cn string, members []string) error {
+		groups[cn] = append(groups[cn], members...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// EachGroupMembers calls fn with the cn and members of every group under the
+// search bases like GetAllGroupMembers, fetching them one page at a time so
+// that they are never all held in memory. It stops at the first error
+// returned by fn and returns it.
+func (lc *LDAPClient) EachGroupMembers(fn func(cn string, members []string) error) error {
+	filter := "(|(objectClass=posixGroup)(objectClass=groupOfNames)(objectClass=groupOfUniqueNames))"
+	if lc.DirectoryType == DirectoryActiveDirectory {
+		filter = "(objectClass=group)"
+	}
+
+	attribute := lc.groupMemberAttribute()
+	return lc.searchPagesAll(filter, []string{"cn", attribute}, lc.searchOptions(), func(entry *ldap.Entry) error {
+		return fn(entry.GetAttributeValue("cn"), entry.GetAttributeValues(attribute))
+	})
+}
+
+// GetOUDescription returns the group for a user.
+func (lc *LDAPClient) GetOUDescription(name string) (string, error) {
+	filter := "(&(objectClass=organizationalUnit)(ou=" + ldap.EscapeFilter(name) + "))"
+	list, err := lc.Filter(filter, []string{"description"})
+	return strings.Join(list, ""), err
+}
+
+// GetOUs returns the names of the organizational units under the base.
+func (lc *LDAPClient) GetOUs() ([]string, error) {
+	return lc.Filter("(objectClass=organizationalUnit)", []string{"ou"})
+}
+
+// GetOUDNs returns the DNs of the organizational units under the base.
+func (lc *LDAPClient) GetOUDNs() ([]string, error) {
+	entries, err := lc.searchEntries("(objectClass=organizationalUnit)", []string{NoAttributes})
+	if err != nil {
+		return nil, err
+	}
+
+	dns := []string{}
+	for _, entry := range entries {
+		dns = append(dns, entry.DN)
+	}
+	return dns, nil
+}
+
+// Filter returns the found entries. It only binds with the read only user, if
+// any, when opening a new connection, so it runs anonymously when no read only
+// user is configured and the directory allows anonymous search.
+func (lc *LDAPClient) Filter(filter string, attributes []string) ([]string, error) {
+	return lc.FilterWithOptions(filter, attributes, lc.searchOptions())
+}
+
+// FilterEntries returns the found entries as is, keeping the DN, the names of
+// the attributes, including operational ones requested with
+// AllOperationalAttributes, and the raw byte values of binary attributes,
+// e.g. with GetRawAttributeValues.
+func (lc *LDAPClient) FilterEntries(filter string, attributes []string) ([]*ldap.Entry, error) {
+	entries, err := lc.searchEntries(filter, attributes)
+	if err != nil {
+		return nil, err
+	}
+	if lc.DecodeObjectIDs {
+		for _, entry := range entries {
+			decodeObjectIDs(entry)
+		}
+	}
+	return entries, nil
+}
+
+// FilterResult runs a search under the search bases like Filter, attaching
+// the given request controls, and returns the whole result, including the
+// response controls and the referrals, which are not followed. With several
+// bases, the results are merged, with the response controls of each search.
+func (lc *LDAPClient) FilterResult(filter string, attributes []string, controls []ldap.Control) (*ldap.SearchResult, error) {
+	err := lc.reconnect()
+	if err != nil {
+		return nil, err
+	}
+
+	options := lc.searchOptions()
+	result := &ldap.SearchResult{Entries: []*ldap.Entry{}, Referrals: []string{}, Controls: []ldap.Control{}}
+	seen := map[string]bool{}
+	for _, base := range lc.searchBases() {
+		searchRequest := ldap.NewSearchRequest(
+			base,
+			options.Scope, options.DerefAliases, options.SizeLimit, options.TimeLimit, options.TypesOnly,
+			filter,
+			attributes,
+			controls,
+		)
+		sr, err := lc.search(searchRequest)
+		if err != nil {
+			return nil, err
+		}
+		result.Entries = appendUniqueEntries(result.Entries, sr.Entries, seen)
+		result.Referrals = append(result.Referrals, sr.Referrals...)
+		result.Controls = append(result.Controls, sr.Controls...)
+	}
+	return result, nil
+}
+
+// FilterEach calls fn with every entry found under the search bases,
+// fetching them one page at a time so that large results are never held in
+// memory. It stops at the first error returned by fn and returns it.
+// Referrals are not followed.
+func (lc *LDAPClient) FilterEach(filter string, attributes []string, fn func(*ldap.Entry) error) error {
+	return lc.searchPagesAll(filter, attributes, lc.searchOptions(), fn)
+}
+
+// FilterPage returns a single page of the entries found under Base, even
+// with SearchBases, as a cookie only continues a single search, starting
+// after the page the cookie was returned with, or at the first page with a
+// nil cookie, along with the cookie of the next page, which is empty after
+// the last page. Servers only accept a cookie on the connection that
+// returned it, e.g. a pooled client kept for the cursor.
+func (lc *LDAPClient) FilterPage(filter string, attributes []string, cookie []byte) ([]*ldap.Entry, []byte, error) {
+	err := lc.reconnect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paging := ldap.NewControlPaging(lc.pageSize())
+	paging.SetCookie(cookie)
+	options := lc.searchOptions()
+	searchRequest := ldap.NewSearchRequest(
+		lc.Base,
+		options.Scope, options.DerefAliases, options.SizeLimit, options.TimeLimit, options.TypesOnly,
+		filter,
+		attributes,
+		[]ldap.Control{paging},
+	)
+	sr, err := lc.search(searchRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sr.Entries, pagingCookie(sr.Controls), nil
+}
+
+// FilterWithReferrals returns the found entries like FilterEntries, along
+// with the URIs of the search references returned, pointing to more entries
+// held by other servers, for the caller to follow. None are returned with
+// FollowReferrals, as the referred entries are returned instead.
+func (lc *LDAPClient) FilterWithReferrals(filter string, attributes []string) ([]*ldap.Entry, []string, error) {
+	entries := []*ldap.Entry{}
+	referrals := []string{}
+	seen := map[string]bool{}
+	for _, base := range lc.searchBases() {
+		found, references, err := lc.searchBaseReferrals(base, filter, attributes, lc.searchOptions())
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = appendUniqueEntries(entries, found, seen)
+		referrals = append(referrals, references...)
+	}
+	return entries, referrals, nil
+}
+
+// FilterWithOptions returns the found entries like Filter, using the given
+// search options instead of the client ones.
+func (lc *LDAPClient) FilterWithOptions(filter string, attributes []string, options SearchOptions) ([]string, error) {
+	cache := lc.searchCache()
+	key := lc.cacheKey(filter, attributes, options)
+	if result, ok := cache.get(key); ok {
+		return result, nil
+	}
+
+	entries, err := lc.searchAll(filter, attributes, options)
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, entry := range entries {
+		for _, attr := range entry.Attributes {
+			for _, value := range attr.Values {
+				result = append(result, value)
+			}
+		}
+	}
+	cache.put(key, result)
+	return result, nil
+}
+
+// GetAttributes returns the values of the given attributes of a known DN.
+// It returns ErrUserNotFound when the DN does not exist.
+func (lc *LDAPClient) GetAttributes(dn string, attributes []string) (map[string][]string, error) {
+	entry, err := lc.getEntry(dn, attributes)
+	if err != nil {
+		return nil, err
+	}
+	if lc.DecodeObjectIDs {
+		decodeObjectIDs(entry)
+	}
+
+	values := map[string][]string{}
+	for _, attr := range entry.Attributes {
+		values[attr.Name] = attr.Values
+	}
+	return values, nil
+}
+
+// GetEntryByDN returns the entry of a known DN with all its user and
+// operational attributes, e.g. to show the raw entry. It returns
+// ErrUserNotFound when the DN does not exist.
+func (lc *LDAPClient) GetEntryByDN(dn string) (*ldap.Entry, error) {
+	entry, err := lc.getEntry(dn, []string{AllUserAttributes, AllOperationalAttributes})
+	if err != nil {
+		return nil, err
+	}
+	if lc.DecodeObjectIDs {
+		decodeObjectIDs(entry)
+	}
+	return entry, nil
+}
+
+// GetOperationalAttributes returns the values of the operational attributes
+// of a known DN, e.g. createTimestamp, creatorsName or pwdChangedTime, along
+// with the user attributes when all is set.
+func (lc *LDAPClient) GetOperationalAttributes(dn string, all bool) (map[string][]string, error) {
+	attributes := []string{AllOperationalAttributes}
+	if all {
+		attributes = append(attributes, AllUserAttributes)
+	}
+	return lc.GetAttributes(dn, attributes)
+}
+
+// GetRawAttributes returns the raw byte values of the given attributes of a
+// known DN, e.g. objectGUID or userCertificate;binary, like GetAttributes.
+func (lc *LDAPClient) GetRawAttributes(dn string, attributes []string) (map[string][][]byte, error) {
+	entry, err := lc.getEntry(dn, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string][][]byte{}
+	for _, attr := range entry.Attributes {
+		values[attr.Name] = attr.ByteValues
+	}
+	return values, nil
+}
+
+// GetAttributeNames returns the names of the attributes set on a known DN,
+// without transferring their values, e.g. to discover which attributes an
+// entry holds. It returns ErrUserNotFound when the DN does not exist.
+func (lc *LDAPClient) GetAttributeNames(dn string) ([]string, error) {
+	err := lc.connectAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, true,
+		"(objectClass=*)",
+		[]string{"*"},
+		nil,
+	)
+	sr, err := lc.search(searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Entries) < 1 {
+		return nil, ErrUserNotFound
+	}
+
+	names := []string{}
+	for _, attr := range sr.Entries[0].Attributes {
+		names = append(names, attr.Name)
+	}
+	return names, nil
+}
+
+// getEntry binds with the read only user and returns the entry of a known DN,
+// or ErrUserNotFound when it does not exist.
+func (lc *LDAPClient) getEntry(dn string, attributes []string) (*ldap.Entry, error) {
+	err := lc.connectAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := lc.readEntry(dn, attributes)
+	if err == errEntryNotFound || ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, ErrUserNotFound
+	}
+	return entry, err
+}
+
+// readEntry returns the entry of a known DN with the given attributes.
+func (lc *LDAPClient) readEntry(dn string, attributes []string) (*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		attributes,
+		nil,
+	)
+	sr, err := lc.search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sr.Entries) < 1 {
+		return nil, errEntryNotFound
+	}
+	return sr.Entries[0], nil
+}
+
+// RawSearch runs a fully built search request after connecting and binding
+// with the read only user, if any, e.g. to use custom controls or bases.
+func (lc *LDAPClient) RawSearch(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	err := lc.connectAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	return lc.search(searchRequest)
+}
+
+// searchOptions returns the configured search options or the default ones.
+func (lc *LDAPClient) searchOptions() SearchOptions {
+	if lc.SearchOptions == nil {
+		return defaultSearchOptions
+	}
+	return *lc.SearchOptions
+}
+
+// searchEntries runs a search under the search bases and returns the entries.
+func (lc *LDAPClient) searchEntries(filter string, attributes []string) ([]*ldap.Entry, error) {
+	return lc.searchAll(filter, attributes, lc.searchOptions())
+}
+
+// searchBases returns SearchBases, or else Base.
+func (lc *LDAPClient) searchBases() []string {
+	if len(lc.SearchBases) > 0 {
+		return lc.SearchBases
+	}
+	return []string{lc.Base}
+}
+
+// searchAll runs a search under every search base and returns the entries,
+// once each when the bases overlap.
+func (lc *LDAPClient) searchAll(filter string, attributes []string, options SearchOptions) ([]*ldap.Entry, error) {
+	bases := lc.searchBases()
+	if len(bases) == 1 {
+		return lc.searchBase(bases[0], filter, attributes, options)
+	}
+
+	entries := []*ldap.Entry{}
+	seen := map[string]bool{}
+	for _, base := range bases {
+		found, err := lc.searchBase(base, filter, attributes, options)
+		if err != nil {
+			return nil, err
+		}
+		entries = appendUniqueEntries(entries, found, seen)
+	}
+	return entries, nil
+}
+
+// appendUniqueEntries appends the found entries whose DN was not seen yet.
+func appendUniqueEntries(entries, found []*ldap.Entry, seen map[string]bool) []*ldap.Entry {
+	for _, entry := range found {
+		if firstSeen(entry, seen) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// firstSeen records the DN of entry as seen, and reports whether it was not
+// seen yet.
+func firstSeen(entry *ldap.Entry, seen map[string]bool) bool {
+	key := strings.ToLower(entry.DN)
+	if normalized, err := NormalizeDN(entry.DN); err == nil {
+		key = normalized
+	}
+	if seen[key] {
+		return false
+	}
+	seen[key] = true
+	return true
+}
+
+// searchPagesAll runs a paged search under every search base and calls fn
+// with every entry, once each when the bases overlap, until fn returns an
+// error.
+func (lc *LDAPClient) searchPagesAll(filter string, attributes []string, options SearchOptions, fn func(*ldap.Entry) error) error {
+	bases := lc.searchBases()
+	if len(bases) == 1 {
+		return lc.searchPages(bases[0], filter, attributes, options, fn)
+	}
+
+	seen := map[string]bool{}
+	for _, base := range bases {
+		err := lc.searchPages(base, filter, attributes, options, func(entry *ldap.Entry) error {
+			if !firstSeen(entry, seen) {
+				return nil
+			}
+			return fn(entry)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// searchBase runs a search under base and returns the entries, including the
+// referred ones when following referrals, and otherwise logs the search
+// references returned.
+func (lc *LDAPClient) searchBase(base, filter string, attributes []string, options SearchOptions) ([]*ldap.Entry, error) {
+	entries, referrals, err := lc.searchBaseReferrals(base, filter, attributes, options)
+	if len(referrals) > 0 && lc.Logger != nil {
+		lc.Logger.Printf("ldap: %d search references under %q not followed, see FollowReferrals", len(referrals), base)
+	}
+	return entries, err
+}
+
+// searchBaseReferrals runs a search under base and returns the entries,
+// including the referred ones when following referrals, and otherwise the
+// URIs of the search references returned.
+func (lc *LDAPClient) searchBaseReferrals(base, filter string, attributes []string, options SearchOptions) ([]*ldap.Entry, []string, error) {
+	err := lc.reconnect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		base,
+		options.Scope, options.DerefAliases, options.SizeLimit, options.TimeLimit, options.TypesOnly,
+		filter,
+		attributes,
+		nil,
+	)
+	sr, err := lc.search(searchRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !lc.FollowReferrals || len(sr.Referrals) == 0 {
+		return sr.Entries, sr.Referrals, nil
+	}
+
+	referred, err := lc.followReferrals(searchRequest, sr.Referrals, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Merge the referred entries, skipping the ones already found
+	entries := sr.Entries
+	found := map[string]bool{}
+	for _, entry := range entries {
+		found[strings.ToLower(entry.DN)] = true
+	}
+	for _, entry := range referred {
+		if !found[strings.ToLower(entry.DN)] {
+			found[strings.ToLower(entry.DN)] = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil, nil
+}
+
+// searchPages runs a paged search under base and calls fn with every entry,
+// page after page, until fn returns an error.
+func (lc *LDAPClient) searchPages(base, filter string, attributes []string, options SearchOptions, fn func(*ldap.Entry) error) error {
+	err := lc.reconnect()
+	if err != nil {
+		return err
+	}
+
+	paging := ldap.NewControlPaging(lc.pageSize())
+	searchRequest := ldap.NewSearchRequest(
+		base,
+		options.Scope, options.DerefAliases, options.SizeLimit, options.TimeLimit, options.TypesOnly,
+		filter,
+		attributes,
+		[]ldap.Control{paging},
+	)
+	for {
+		sr, err := lc.search(searchRequest)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range sr.Entries {
+			err = fn(entry)
+			if err != nil {
+				lc.abandonPages(searchRequest, paging)
+				return err
+			}
+		}
+
+		cookie := pagingCookie(sr.Controls)
+		if len(cookie) == 0 {
+			return nil
+		}
+		paging.SetCookie(cookie)
+	}
+}
+
+// abandonPages lets the server release the state of a paged search stopped
+// before its last page, by requesting an empty page.
+func (lc *LDAPClient) abandonPages(searchRequest *ldap.SearchRequest, paging *ldap.ControlPaging) {
+	if lc.Conn == nil || len(paging.Cookie) == 0 {
+		return
+	}
+	paging.PagingSize = 0
+	lc.search(searchRequest)
+}
+
+// pagingCookie returns the cookie of the paging response control, which is
+// empty after the last page.
+func pagingCookie(controls []ldap.Control) []byte {
+	paging, ok := ldap.FindControl(controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+	if !ok {
+		return nil
+	}
+	return paging.Cookie
+}
+
+// pageSize returns the configured page size or the default one.
+func (lc *LDAPClient) pageSize() uint32 {
+	if lc.PageSize == 0 {
+		return defaultPageSize
+	}
+	return lc.PageSize
+}
+
+// DelGroup delete an existing group.
+func (lc *LDAPClient) DelGroup(groupName, ou string) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	groupDN := lc.groupDN(groupName, ou)
+	delRequest := ldap.NewDelRequest(groupDN, []ldap.Control{})
+
+	return lc.del(delRequest)
+}
+
+// AddGroup persist a new group.
+func (lc *LDAPClient) AddGroup(groupName, gidNumber, ou string) error {
+	groupDN := lc.groupDN(groupName, ou)
+	return lc.AddEntry(groupDN, map[string][]string{
+		"objectClass": {"posixGroup"},
+		"gidNumber":   {gidNumber},
+	})
+}
+
+// CreateGroup persist a new group with the given initial members.
+// An Active Directory group is a global security group listing members by
+// DN. Otherwise a posixGroup gets the next free gidNumber and lists members
+// by uid, while a groupOfNames lists members by DN and must have at least one
+// of them. GroupAttributes are added to, or override, these attributes.
+func (lc *LDAPClient) CreateGroup(groupname, ou string, initialMembers []string) error {
+	groupDN := lc.groupDN(groupname, ou)
+
+	var attributes map[string][]string
+	switch {
+	case lc.DirectoryType == DirectoryActiveDirectory:
+		attributes = map[string][]string{
+			"objectClass":    {"group"},
+			"sAMAccountName": {groupname},
+			"groupType":      {adGlobalSecurityGroup},
+		}
+		if len(initialMembers) > 0 {
+			attributes["member"] = initialMembers
+		}
+	case lc.GroupObjectClass == "groupOfNames":
+		if len(initialMembers) == 0 {
+			return errors.New("groupOfNames requires at least one member")
+		}
+		attributes = map[string][]string{
+			"objectClass": {"groupOfNames"},
+			"member":      initialMembers,
+		}
+	default:
+		gidNumber, err := lc.nextGIDNumber()
+		if err != nil {
+			return err
+		}
+
+		attributes = map[string][]string{
+			"objectClass": {"posixGroup"},
+			"gidNumber":   {strconv.Itoa(gidNumber)},
+		}
+		if len(initialMembers) > 0 {
+			attributes["memberUid"] = initialMembers
+		}
+	}
+
+	for name, values := range lc.GroupAttributes {
+		attributes[name] = values
+	}
+	return lc.AddEntry(groupDN, attributes)
+}
+
+// DeleteGroup deletes an existing group.
+func (lc *LDAPClient) DeleteGroup(groupname, ou string) error {
+	return lc.DelGroup(groupname, ou)
+}
+
+// nextGIDNumber returns a gidNumber above any posixGroup in the directory.
+func (lc *LDAPClient) nextGIDNumber() (int, error) {
+	values, err := lc.Filter("(objectClass=posixGroup)", []string{"gidNumber"})
+	if err != nil {
+		return 0, err
+	}
+
+	next := firstGIDNumber
+	for _, value := range values {
+		gid, err := strconv.Atoi(value)
+		if err == nil && gid >= next {
+			next = gid + 1
+		}
+	}
+	return next, nil
+}
+
+// AddUser persist a new user.
+func (lc *LDAPClient) AddUser(username, password, ou string) error {
+	userDN := lc.userDN(username, ou)
+	return lc.AddEntry(userDN, map[string][]string{
+		"objectClass":  {"inetOrgPerson"},
+		"userPassword": {lc.hashPassword(password)},
+		"sn":           {username},
+		"uid":          {username},
+	})
+}
+
+// AddUserAccount persist a new user account.
+func (lc *LDAPClient) AddUserAccount(account AddUserAccount) error {
+	return lc.AddEntry(lc.userAccountEntry(account))
+}
+
+// AddUsers persist new user accounts, binding once for the whole batch.
+// The results hold the error of every account, if any, so that one failure
+// does not abort the batch. The batch stops with an error when connecting
+// or binding fails, or when the connection is lost.
+func (lc *LDAPClient) AddUsers(accounts []AddUserAccount) ([]error, error) {
+	err := lc.connectAdmin()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]error, len(accounts))
+	for i, account := range accounts {
+		results[i] = lc.addEntry(lc.userAccountEntry(account))
+		if lc.Conn == nil {
+			// The remaining accounts cannot be added either
+			for j := i + 1; j < len(accounts); j++ {
+				results[j] = results[i]
+			}
+			return results, results[i]
+		}
+	}
+	return results, nil
+}
+
+// userAccountEntry returns the DN and attributes of a new user account.
+func (lc *LDAPClient) userAccountEntry(account AddUserAccount) (string, map[string][]string) {
+	objectClasses := append([]string{}, defaultUserAccountObjectClasses...)
+	objectClasses = append(objectClasses, account.ObjectClasses...)
+	if account.ShadowLastChange != 0 || account.ShadowMax != 0 {
+		objectClasses = append(objectClasses, "shadowAccount")
+	}
+
+	homeDirectory := account.HomeDirectory
+	if homeDirectory == "" {
+		homeDirectory = "/home/" + account.Username
+	}
+	loginShell := account.LoginShell
+	if loginShell == "" {
+		loginShell = "/bin/bash"
+	}
+
+	attributes := map[string][]string{
+		"objectClass":   uniqueValues(objectClasses),
+		"uidNumber":     {strconv.Itoa(account.UID)},
+		"gidNumber":     {strconv.Itoa(account.GID)},
+		"userPassword":  {lc.hashPassword(account.Password)},
+		"homeDirectory": {homeDirectory},
+		"loginShell":    {loginShell},
+		"sn":            {account.Username},
+		"uid":           {account.Username},
+	}
+	if account.ShadowLastChange != 0 {
+		attributes["shadowLastChange"] = []string{strconv.Itoa(account.ShadowLastChange)}
+	}
+	if account.ShadowMax != 0 {
+		attributes["shadowMax"] = []string{strconv.Itoa(account.ShadowMax)}
+	}
+	for name, values := range account.Extra {
+		attributes[name] = values
+	}
+
+	return lc.userDN(account.Username, account.OU), attributes
+}
+
+// uniqueValues returns the values without the case insensitive duplicates,
+// keeping the first occurrences in order.
+func uniqueValues(values []string) []string {
+	var unique []string
+	seen := map[string]bool{}
+	for _, value := range values {
+		if !seen[strings.ToLower(value)] {
+			seen[strings.ToLower(value)] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// AddEntry persist a new entry with the given attributes, which must include
+// its objectClass values.
+func (lc *LDAPClient) AddEntry(dn string, attributes map[string][]string) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	return lc.addEntry(dn, attributes)
+}
+
+// addEntry adds an entry on the connection, which must be bound.
+func (lc *LDAPClient) addEntry(dn string, attributes map[string][]string) error {
+	addRequest := ldap.NewAddRequest(dn, nil)
+	for _, name := range sortedNames(attributes) {
+		addRequest.Attribute(name, attributes[name])
+	}
+
+	return lc.add(addRequest)
+}
+
+// sortedNames returns the attribute names of a map in order, so that the
+// requests built from it are deterministic.
+func sortedNames(attributes map[string][]string) []string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MoveUser moves a user from an OU to another. With MoveGroupMemberships,
+// the groups listing the user by DN in member or uniqueMember are updated
+// with the new DN, while groups listing members by uid need no change.
+func (lc *LDAPClient) MoveUser(username, ou, newOU string) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	oldDN := lc.userDN(username, ou)
+	newDN := lc.userDN(username, newOU)
+	rdn, newSuperior := splitRDN(newDN)
+	err = lc.modifyDN(ldap.NewModifyDNRequest(oldDN, rdn, true, newSuperior))
+	if err != nil {
+		return err
+	}
+
+	if !lc.MoveGroupMemberships {
+		return nil
+	}
+	return lc.updateMemberReferences(oldDN, newDN)
+}
+
+// RenameGroup renames a group of an OU, changing its cn. With
+// MoveGroupMemberships, the groups listing it by DN in member or
+// uniqueMember, i.e. nesting it, are updated with the new DN. The memberOf
+// values of its members are maintained by the server.
+func (lc *LDAPClient) RenameGroup(oldName, newName, ou string) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	oldDN := lc.groupDN(oldName, ou)
+	newDN := lc.groupDN(newName, ou)
+	rdn, _ := splitRDN(newDN)
+	err = lc.modifyDN(ldap.NewModifyDNRequest(oldDN, rdn, true, ""))
+	if err != nil {
+		return err
+	}
+
+	if !lc.MoveGroupMemberships {
+		return nil
+	}
+	return lc.updateMemberReferences(oldDN, newDN)
+}
+
+// updateMemberReferences replaces oldDN with newDN in the member and
+// uniqueMember values of the groups under the base.
+func (lc *LDAPClient) updateMemberReferences(oldDN, newDN string) error {
+	dn := ldap.EscapeFilter(oldDN)
+	groups, err := lc.searchEntries(fmt.Sprintf("(|(member=%s)(uniqueMember=%s))", dn, dn), []string{"member", "uniqueMember"})
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		modifyRequest := ldap.NewModifyRequest(group.DN, nil)
+		for _, attr := range []string{"member", "uniqueMember"} {
+			for _, value := range group.GetAttributeValues(attr) {
+				if DNEqual(value, oldDN) {
+					modifyRequest.Delete(attr, []string{value})
+					modifyRequest.Add(attr, []string{newDN})
+				}
+			}
+		}
+		if len(modifyRequest.Changes) == 0 {
+			continue
+		}
+
+		err = lc.modify(modifyRequest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitRDN splits a DN into its first RDN and the DN of its parent, minding
+// escaped commas.
+func splitRDN(dn string) (rdn, parent string) {
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			return dn[:i], dn[i+1:]
+		}
+	}
+	return dn, ""
+}
+
+// ChangeMembers updates the members of a given group, in the
+// GroupMemberAttribute. With ResolveMemberDNs, the members are given by uid
+// and resolved to the DNs stored in member or uniqueMember.
+func (lc *LDAPClient) ChangeMembers(members []string, groupname, ou string) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	values, err := lc.memberValues(members)
+	if err != nil {
+		return err
+	}
+
+	modifyRequest := ldap.NewModifyRequest(lc.groupDN(groupname, ou), nil)
+	modifyRequest.Replace(lc.groupMemberAttribute(), values)
+	return lc.modify(modifyRequest)
+}
+
+// memberValues returns the values of GroupMemberAttribute for the given
+// members, resolving uids to DNs with ResolveMemberDNs.
+func (lc *LDAPClient) memberValues(members []string) ([]string, error) {
+	if lc.groupMemberAttribute() == "memberUid" || !lc.ResolveMemberDNs {
+		return members, nil
+	}
+
+	dns := make([]string, 0, len(members))
+	for _, member := range members {
+		entry, err := lc.findUser(member, []string{"dn"})
+		if err != nil {
+			return nil, err
+		}
+		dns = append(dns, entry.DN)
+	}
+	return dns, nil
+}
+
+// SyncMembers updates the members of a given group like ChangeMembers, only
+// adding and removing the members that differ from the current ones.
+func (lc *LDAPClient) SyncMembers(members []string, groupname, ou string) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	values, err := lc.memberValues(members)
+	if err != nil {
+		return err
+	}
+
+	DN := lc.groupDN(groupname, ou)
+	attribute := lc.groupMemberAttribute()
+	group, err := lc.readEntry(DN, []string{attribute})
+	if err != nil {
+		return err
+	}
 
-			// Reconnect with TLS
-			if !lc.SkipTLS {
-				err = l.StartTLS(&tls.Config{InsecureSkipVerify: true})
-				if err != nil {
-					return err
-				}
-			}
-		} else {
-			l, err = ldap.DialTLS("tcp", address, &tls.Config{
-				InsecureSkipVerify: lc.InsecureSkipVerify,
-				ServerName:         lc.ServerName,
-			})
-			if err != nil {
-				return err
-			}
+	current := group.GetAttributeValues(attribute)
+	added, removed := diffValues(current, values)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	modifyRequest := ldap.NewModifyRequest(DN, nil)
+	if len(added) > 0 {
+		modifyRequest.Add(attribute, added)
+	}
+	if len(values) == 0 {
+		// Remove the attribute altogether
+		modifyRequest.Delete(attribute, []string{})
+	} else if len(removed) > 0 {
+		modifyRequest.Delete(attribute, removed)
+	}
+	return lc.modify(modifyRequest)
+}
+
+// diffValues returns the values of target missing from current, and the
+// values of current missing from target.
+func diffValues(current, target []string) (added, removed []string) {
+	inCurrent := map[string]bool{}
+	for _, value := range current {
+		inCurrent[value] = true
+	}
+	inTarget := map[string]bool{}
+	for _, value := range target {
+		if !inCurrent[value] && !inTarget[value] {
+			added = append(added, value)
+		}
+		inTarget[value] = true
+	}
+	for _, value := range current {
+		if !inTarget[value] {
+			removed = append(removed, value)
 		}
+	}
+	return added, removed
+}
+
+// ChangeDescription updates the description of a given OU.
+func (lc *LDAPClient) ChangeDescription(description, ou string) error {
+	return lc.ChangeAttribute(lc.ouDN(ou), "description", []string{description})
+}
 
-		lc.Conn = l
+// CreateOU persist a new organizational unit under the base. The description
+// is omitted when empty.
+func (lc *LDAPClient) CreateOU(ou, description string) error {
+	attributes := map[string][]string{
+		"objectClass": {"organizationalUnit"},
+		"ou":          {ou},
 	}
-	return nil
+	if description != "" {
+		attributes["description"] = []string{description}
+	}
+	return lc.AddEntry(lc.ouDN(ou), attributes)
 }
 
-// Close closes the ldap backend connection.
-func (lc *LDAPClient) Close() {
-	if lc.Conn != nil {
-		lc.Conn.Close()
-		lc.Conn = nil
+// DeleteOU deletes an empty organizational unit. It returns ErrNotLeaf when
+// the OU still holds entries, see DeleteTree to delete them along.
+func (lc *LDAPClient) DeleteOU(ou string) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	delRequest := ldap.NewDelRequest(lc.ouDN(ou), nil)
+	err = lc.del(delRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNotAllowedOnNonLeaf) {
+		return ErrNotLeaf
 	}
+	return err
 }
 
-// Authenticate authenticates the user against the ldap backend.
-func (lc *LDAPClient) Authenticate(username, password string) (bool, map[string]string, error) {
-	err := lc.Connect()
+// DeleteTree deletes an entry along with all its subordinates, e.g. an OU full
+// of users. It uses the subtree delete control when the server supports it,
+// and otherwise deletes the entries one by one, leaves first.
+func (lc *LDAPClient) DeleteTree(dn string) error {
+	err := lc.connectAdmin()
 	if err != nil {
-		return false, nil, err
+		return err
 	}
 
-	// First bind with a read only user
-	if lc.BindDN != "" && lc.BindPassword != "" {
-		err := lc.Conn.Bind(lc.BindDN, lc.BindPassword)
+	supported, err := lc.supportsControl(controlTypeSubtreeDelete)
+	if err != nil {
+		return err
+	}
+	if supported {
+		delRequest := ldap.NewDelRequest(dn, []ldap.Control{ldap.NewControlString(controlTypeSubtreeDelete, true, "")})
+		return lc.del(delRequest)
+	}
+
+	// Page through the subtree, which may exceed the size limit, before
+	// deleting anything
+	dns := []string{}
+	err = lc.searchPages(dn, "(objectClass=*)", []string{NoAttributes}, defaultSearchOptions, func(entry *ldap.Entry) error {
+		dns = append(dns, entry.DN)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, entryDN := range leavesFirst(dns) {
+		err = lc.del(ldap.NewDelRequest(entryDN, nil))
 		if err != nil {
-			return false, nil, err
+			return err
 		}
 	}
+	return nil
+}
 
-	attributes := append(lc.Attributes, "dn")
-	// Search for the given username
-	searchRequest := ldap.NewSearchRequest(
-		lc.Base,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		fmt.Sprintf(lc.UserFilter, username),
-		attributes,
-		nil,
-	)
+// leavesFirst sorts DNs so that every entry comes before its parent, as the
+// DN of a child is always longer than the one of its parent.
+func leavesFirst(dns []string) []string {
+	sort.SliceStable(dns, func(i, j int) bool {
+		return len(dns[i]) > len(dns[j])
+	})
+	return dns
+}
 
-	sr, err := lc.Conn.Search(searchRequest)
+// supportsControl reports whether the root DSE advertises a control.
+func (lc *LDAPClient) supportsControl(controlType string) (bool, error) {
+	return lc.rootDSEAdvertises("supportedControl", controlType)
+}
+
+// rootDSEAdvertises reports whether an attribute of the root DSE, e.g.
+// supportedExtension, holds the given OID.
+func (lc *LDAPClient) rootDSEAdvertises(attribute, oid string) (bool, error) {
+	entry, err := lc.readEntry("", []string{attribute})
+	if err == errEntryNotFound {
+		return false, nil
+	}
 	if err != nil {
-		return false, nil, err
+		return false, err
 	}
 
-	if len(sr.Entries) < 1 {
-		return false, nil, errors.New("User does not exist")
+	for _, value := range entry.GetAttributeValues(attribute) {
+		if value == oid {
+			return true, nil
+		}
 	}
+	return false, nil
+}
+
+// ouDN returns the DN of an organizational unit under the base.
+func (lc *LDAPClient) ouDN(ou string) string {
+	return fmt.Sprintf("ou=%s,%s", EscapeDN(ou), lc.Base)
+}
 
-	if len(sr.Entries) > 1 {
-		return false, nil, errors.New("Too many entries returned")
+// ChangePassword updates the password of a given user. It returns a
+// PasswordPolicyError when the password breaks the policy of the server.
+func (lc *LDAPClient) ChangePassword(password, username, ou string) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
 	}
 
-	userDN := sr.Entries[0].DN
-	user := map[string]string{}
-	for _, attr := range lc.Attributes {
-		user[attr] = sr.Entries[0].GetAttributeValue(attr)
+	// Ask for the password policy response of the OpenLDAP ppolicy overlay
+	modifyRequest := ldap.NewModifyRequest(lc.userDN(username, ou), []ldap.Control{ldap.NewControlBeheraPasswordPolicy()})
+	modifyRequest.Replace("userPassword", []string{lc.hashPassword(password)})
+	return passwordPolicyError(lc.modify(modifyRequest))
+}
+
+// hashPassword applies the HashPassword function if one is configured.
+func (lc *LDAPClient) hashPassword(password string) string {
+	if lc.HashPassword == nil {
+		return password
 	}
+	return lc.HashPassword(password)
+}
 
-	// Bind as the user to verify their password
-	err = lc.Conn.Bind(userDN, password)
+// SetPassword resets the password of a user as an admin. Active Directory
+// requires the quoted UTF-16LE unicodePwd to be replaced over a TLS connection,
+// other directories get the password modify extended operation. It returns a
+// PasswordPolicyError when the password breaks the policy of the server.
+func (lc *LDAPClient) SetPassword(userDN, newPassword string) error {
+	err := lc.connectAdmin()
 	if err != nil {
-		return false, user, err
+		return err
 	}
 
-	// Rebind as the read only user for any further queries
-	if lc.BindDN != "" && lc.BindPassword != "" {
-		err = lc.Conn.Bind(lc.BindDN, lc.BindPassword)
-		if err != nil {
-			return true, user, err
-		}
+	ad, err := lc.activeDirectory()
+	if err != nil {
+		return err
 	}
 
-	return true, user, nil
-}
+	if !ad {
+		passwordModifyRequest := ldap.NewPasswordModifyRequest(userDN, "", newPassword)
+		return passwordPolicyError(lc.passwordModify(passwordModifyRequest, ldap.NewControlBeheraPasswordPolicy()))
+	}
 
-// GetGroupsOfUser returns the group for a user.
-func (lc *LDAPClient) GetGroupsOfUser(username string) ([]string, error) {
-	return lc.Filter(fmt.Sprintf(lc.GroupFilter, username), []string{"cn"})
-}
+	if !lc.UseSSL && lc.SkipTLS {
+		return errors.New("Setting an Active Directory password requires TLS")
+	}
 
-// GetAllGroups returns the group for a user.
-func (lc *LDAPClient) GetAllGroups() ([]string, error) {
-	filter := "(objectClass=posixGroup)"
-	return lc.Filter(filter, []string{"cn"})
+	modifyRequest := ldap.NewModifyRequest(userDN, []ldap.Control{ldap.NewControlBeheraPasswordPolicy()})
+	modifyRequest.Replace("unicodePwd", []string{encodeADPassword(newPassword)})
+	return passwordPolicyError(lc.modify(modifyRequest))
 }
 
-// GetOUDescription returns the group for a user.
-func (lc *LDAPClient) GetOUDescription(name string) (string, error) {
-	filter := "(&(objectClass=organizationalUnit)(ou=" + name + "))"
-	list, err := lc.Filter(filter, []string{"description"})
-	return strings.Join(list, ""), err
+// rootDSEAttributes are read by RootDSE, listed as Active Directory does not
+// support "+" for all operational attributes.
+var rootDSEAttributes = []string{
+	"*", "+",
+	"namingContexts", "defaultNamingContext", "subschemaSubentry",
+	"supportedControl", "supportedExtension", "supportedFeatures",
+	"supportedSASLMechanisms", "supportedLDAPVersion", "supportedCapabilities",
+	"vendorName", "vendorVersion", "dnsHostName",
 }
 
-// Filter returns the found entries.
-func (lc *LDAPClient) Filter(filter string, attributes []string) ([]string, error) {
-	err := lc.Connect()
+// RootDSE returns the root DSE of the server, e.g. to check its
+// supportedControl, supportedExtension or supportedSASLMechanisms values
+// before relying on them.
+func (lc *LDAPClient) RootDSE() (*ldap.Entry, error) {
+	err := lc.connectAdmin()
 	if err != nil {
 		return nil, err
 	}
 
+	return lc.readEntry("", rootDSEAttributes)
+}
+
+// isActiveDirectory reports whether the root DSE advertises Active Directory.
+func (lc *LDAPClient) isActiveDirectory() (bool, error) {
 	searchRequest := ldap.NewSearchRequest(
-		lc.Base,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		filter,
-		attributes,
+		"",
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		[]string{"supportedCapabilities"},
 		nil,
 	)
-	sr, err := lc.Conn.Search(searchRequest)
+	sr, err := lc.search(searchRequest)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	result := []string{}
+
 	for _, entry := range sr.Entries {
-		for _, attr := range entry.Attributes {
-			for _, value := range attr.Values {
-				result = append(result, value)
+		for _, capability := range entry.GetAttributeValues("supportedCapabilities") {
+			if capability == activeDirectoryCapability {
+				return true, nil
 			}
 		}
 	}
-	return result, nil
+	return false, nil
 }
 
-// DelGroup delete an existing group.
-func (lc *LDAPClient) DelGroup(groupName, ou string) error {
+// connectAdmin connects and binds with the admin user, if any.
+func (lc *LDAPClient) connectAdmin() error {
 	err := lc.Connect()
 	if err != nil {
 		return err
 	}
 
-	// First bind with an admin user
-	if lc.BindDN != "" && lc.BindPassword != "" {
-		err := lc.Conn.Bind(lc.BindDN, lc.BindPassword)
-		if err != nil {
-			return err
-		}
+	return lc.Rebind()
+}
+
+// userFilter returns the user filter for username, escaped so that it
+// cannot alter the filter, e.g. "*)(uid=*" does not match every user.
+func (lc *LDAPClient) userFilter(username string) string {
+	if lc.UserFilter == "" && lc.DirectoryType == DirectoryActiveDirectory {
+		return adUserFilter(username)
 	}
+	return expandFilter(lc.UserFilter, username)
+}
 
-	groupDN := fmt.Sprintf("cn=%s,ou=%s,%s", groupName, ou, lc.Base)
-	delRequest := ldap.NewDelRequest(groupDN, []ldap.Control{})
+// groupFilter returns the group filter for username, escaped like userFilter.
+func (lc *LDAPClient) groupFilter(username string) string {
+	return expandFilter(lc.GroupFilter, username)
+}
 
-	return lc.Conn.Del(delRequest)
+// userDN returns the DN of a user in the given OU.
+func (lc *LDAPClient) userDN(username, ou string) string {
+	template := lc.UserDNTemplate
+	if template == "" {
+		template = defaultDNTemplate
+	}
+	return fmt.Sprintf(template, EscapeDN(username), EscapeDN(ou), lc.Base)
 }
 
-// AddGroup persist a new group.
-func (lc *LDAPClient) AddGroup(groupName, gidNumber, ou string) error {
-	err := lc.Connect()
+// groupDN returns the DN of a group in the given OU.
+func (lc *LDAPClient) groupDN(groupname, ou string) string {
+	template := lc.GroupDNTemplate
+	if template == "" {
+		template = defaultDNTemplate
+	}
+	return fmt.Sprintf(template, EscapeDN(groupname), EscapeDN(ou), lc.Base)
+}
+
+// ChangeAttribute updates the attribute values of a given DN.
+func (lc *LDAPClient) ChangeAttribute(DN, attribute string, values []string) error {
+	err := lc.connectAdmin()
 	if err != nil {
 		return err
 	}
 
-	// First bind with an admin user
-	if lc.BindDN != "" && lc.BindPassword != "" {
-		err := lc.Conn.Bind(lc.BindDN, lc.BindPassword)
-		if err != nil {
-			return err
-		}
+	modifyRequest := ldap.NewModifyRequest(DN, nil)
+	modifyRequest.Replace(attribute, values)
+
+	return lc.modify(modifyRequest)
+}
+
+// SetBinaryAttribute replaces the value of a binary attribute of a given DN,
+// e.g. jpegPhoto, with data sent as is, or removes it when data is empty.
+func (lc *LDAPClient) SetBinaryAttribute(dn, attribute string, data []byte) error {
+	// Go strings hold arbitrary bytes, which are encoded unchanged
+	var values []string
+	if len(data) > 0 {
+		values = []string{string(data)}
+	}
+	return lc.ChangeAttribute(dn, attribute, values)
+}
+
+// GetBinaryAttribute returns the raw first value of a binary attribute of a
+// given DN, e.g. jpegPhoto, or nil when it has none. It returns
+// ErrUserNotFound when the DN does not exist.
+func (lc *LDAPClient) GetBinaryAttribute(dn, attribute string) ([]byte, error) {
+	entry, err := lc.getEntry(dn, []string{attribute})
+	if err != nil {
+		return nil, err
 	}
+	return entry.GetRawAttributeValue(attribute), nil
+}
 
-	userDN := fmt.Sprintf("cn=%s,ou=%s,%s", groupName, ou, lc.Base)
-	addRequest := ldap.NewAddRequest(userDN)
+// SetAttribute makes an attribute of a given DN hold exactly the given
+// values, whether present or not, removing it when there are none. It
+// replaces the attribute, and adds it instead on the servers rejecting the
+// replacement of a missing attribute.
+func (lc *LDAPClient) SetAttribute(dn, attribute string, values []string) error {
+	err := lc.ChangeAttribute(dn, attribute, values)
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+		return err
+	}
 
-	addRequest.Attribute("objectClass", []string{"posixGroup"})
-	addRequest.Attribute("gidNumber", []string{gidNumber})
+	// Already missing
+	if len(values) == 0 {
+		return nil
+	}
+	return lc.changeValues(dn, attribute, values, true)
+}
 
-	return lc.Conn.Add(addRequest)
+// ChangeAttributeAdd adds values to a multi-valued attribute of a given DN,
+// keeping its other values.
+func (lc *LDAPClient) ChangeAttributeAdd(dn, attribute string, values []string) error {
+	return lc.changeValues(dn, attribute, values, true)
 }
 
-// AddUser persist a new user.
-func (lc *LDAPClient) AddUser(username, password, ou string) error {
-	err := lc.Connect()
+// ChangeAttributeDelete deletes values from a multi-valued attribute of a
+// given DN, keeping its other values. Deleting no values removes the
+// attribute altogether.
+func (lc *LDAPClient) ChangeAttributeDelete(dn, attribute string, values []string) error {
+	return lc.changeValues(dn, attribute, values, false)
+}
+
+// changeValues adds or deletes attribute values, tolerating the ones already
+// present or missing with IgnoreValueConflicts.
+func (lc *LDAPClient) changeValues(dn, attribute string, values []string, add bool) error {
+	err := lc.connectAdmin()
 	if err != nil {
 		return err
 	}
 
-	// First bind with an admin user
-	if lc.BindDN != "" && lc.BindPassword != "" {
-		err := lc.Conn.Bind(lc.BindDN, lc.BindPassword)
-		if err != nil {
+	conflict := uint16(ldap.LDAPResultNoSuchAttribute)
+	if add {
+		conflict = ldap.LDAPResultAttributeOrValueExists
+	}
+	modify := func(values []string) error {
+		modifyRequest := ldap.NewModifyRequest(dn, nil)
+		if add {
+			modifyRequest.Add(attribute, values)
+		} else {
+			modifyRequest.Delete(attribute, values)
+		}
+		return lc.modify(modifyRequest)
+	}
+
+	err = modify(values)
+	if !lc.IgnoreValueConflicts || !ldap.IsErrorWithCode(err, conflict) {
+		return err
+	}
+
+	// The request was rejected as a whole, change the values one by one
+	for _, value := range values {
+		err = modify([]string{value})
+		if err != nil && !ldap.IsErrorWithCode(err, conflict) {
 			return err
 		}
 	}
+	return nil
+}
 
-	userDN := fmt.Sprintf("cn=%s,ou=%s,%s", username, ou, lc.Base)
-	addRequest := ldap.NewAddRequest(userDN)
+// ModifyAttributes adds, deletes and replaces attribute values of a given DN
+// in a single modify request, so that either all changes apply or none.
+// Deleting an attribute with no values removes it altogether.
+func (lc *LDAPClient) ModifyAttributes(dn string, add, del, replace map[string][]string) error {
+	err := lc.connectAdmin()
+	if err != nil {
+		return err
+	}
 
-	addRequest.Attribute("objectClass", []string{"inetOrgPerson"})
-	addRequest.Attribute("userPassword", []string{password})
-	addRequest.Attribute("sn", []string{username})
-	addRequest.Attribute("uid", []string{username})
+	return lc.modify(newModifyRequest(dn, add, del, replace))
+}
 
-	return lc.Conn.Add(addRequest)
+// ModifyIfUnchanged applies the changes of ModifyAttributes only when the
+// modifyTimestamp of the entry still is the one read by the caller, and
+// returns ErrEntryChanged otherwise. The server checks it when performing the
+// modify, with an assertion control, so that concurrent updates are not lost.
+func (lc *LDAPClient) ModifyIfUnchanged(dn, expectedModifyTimestamp string, add, del, replace map[string][]string) error {
+	filter := fmt.Sprintf("(modifyTimestamp=%s)", ldap.EscapeFilter(expectedModifyTimestamp))
+	err := lc.ModifyWithAssertion(dn, filter, add, del, replace)
+	if err == ErrAssertionFailed {
+		return ErrEntryChanged
+	}
+	return err
 }
 
-// AddUserAccount persist a new user account.
-func (lc *LDAPClient) AddUserAccount(account AddUserAccount) error {
+// ModifyWithAssertion applies the changes of ModifyAttributes only when the
+// entry matches the assertion filter at write time, e.g.
+// "(memberUid=alice)", and returns ErrAssertionFailed otherwise.
+func (lc *LDAPClient) ModifyWithAssertion(dn, assertionFilter string, add, del, replace map[string][]string) error {
+	control, err := NewControlAssertion(assertionFilter)
+	if err != nil {
+		return err
+	}
+
+	err = lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	modifyRequest := newModifyRequest(dn, add, del, replace)
+	modifyRequest.Controls = append(modifyRequest.Controls, control)
+	err = lc.modify(modifyRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultAssertionFailed) {
+		return ErrAssertionFailed
+	}
+	return err
+}
+
+// DeleteWithAssertion deletes an entry only when it matches the assertion
+// filter at write time, and returns ErrAssertionFailed otherwise.
+func (lc *LDAPClient) DeleteWithAssertion(dn, assertionFilter string) error {
+	control, err := NewControlAssertion(assertionFilter)
+	if err != nil {
+		return err
+	}
+
+	err = lc.connectAdmin()
+	if err != nil {
+		return err
+	}
+
+	err = lc.del(ldap.NewDelRequest(dn, []ldap.Control{control}))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultAssertionFailed) {
+		return ErrAssertionFailed
+	}
+	return err
+}
+
+// NewControlAssertion returns a critical RFC 4528 assertion control, which
+// makes the server perform an operation only when the target entry matches
+// the filter.
+func NewControlAssertion(filter string) (ldap.Control, error) {
+	packet, err := ldap.CompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	return ldap.NewControlString(controlTypeAssertion, true, string(packet.Bytes())), nil
+}
+
+// newModifyRequest builds a modify request with the given changes.
+func newModifyRequest(dn string, add, del, replace map[string][]string) *ldap.ModifyRequest {
+	modifyRequest := ldap.NewModifyRequest(dn, nil)
+	for _, name := range sortedNames(add) {
+		modifyRequest.Add(name, add[name])
+	}
+	for _, name := range sortedNames(del) {
+		modifyRequest.Delete(name, del[name])
+	}
+	for _, name := range sortedNames(replace) {
+		modifyRequest.Replace(name, replace[name])
+	}
+	return modifyRequest
+}
+
+// bind binds the connection as dn, or anonymously when dn is empty.
+func (lc *LDAPClient) bind(dn, password string) error {
 	err := lc.Connect()
 	if err != nil {
 		return err
 	}
 
-	// First bind with an admin user
-	if lc.BindDN != "" && lc.BindPassword != "" {
-		err := lc.Conn.Bind(lc.BindDN, lc.BindPassword)
+	lc.boundAsBindUser = false
+	lc.boundDN = ""
+	err = lc.bindConn(lc.Conn, dn, password)
+	if err == nil {
+		lc.boundDN = dn
+	}
+	lc.failover(err)
+	return err
+}
+
+// bindConn binds a given connection as dn, or anonymously when dn is empty.
+func (lc *LDAPClient) bindConn(conn *ldap.Conn, dn, password string) error {
+	if dn != "" && password == "" && !lc.AllowUnauthenticatedBind {
+		return ErrUnauthenticatedBind
+	}
+
+	start := time.Now()
+	err := conn.Bind(dn, password)
+	lc.trace("bind", fmt.Sprintf("dn=%q", dn), start, err)
+	return err
+}
+
+// search runs a search request on the connection.
+func (lc *LDAPClient) search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	searchRequest.Controls = lc.controls(searchRequest.Controls)
+
+	var sr *ldap.SearchResult
+	err := lc.retry(true, func() error {
+		err := lc.redial()
 		if err != nil {
 			return err
 		}
-	}
 
-	userDN := fmt.Sprintf("cn=%s,ou=%s,%s", account.Username, account.OU, lc.Base)
-	addRequest := ldap.NewAddRequest(userDN)
+		start := time.Now()
+		sr, err = lc.Conn.Search(searchRequest)
+		lc.trace("search", fmt.Sprintf("base=%q filter=%q", searchRequest.BaseDN, searchRequest.Filter), start, err)
+		lc.failover(err)
+		return err
+	})
+	return sr, err
+}
 
-	addRequest.Attribute("objectClass", []string{"inetOrgPerson", "posixAccount"})
-	addRequest.Attribute("uidNumber", []string{strconv.Itoa(account.UID)})
-	addRequest.Attribute("gidNumber", []string{strconv.Itoa(account.GID)})
-	addRequest.Attribute("userPassword", []string{account.Password})
-	addRequest.Attribute("homeDirectory", []string{"/home/" + account.Username})
-	addRequest.Attribute("loginShell", []string{"/bin/bash"})
-	addRequest.Attribute("sn", []string{account.Username})
-	addRequest.Attribute("uid", []string{account.Username})
+// compare runs a compare request on the connection.
+func (lc *LDAPClient) compare(dn, attribute, value string) (bool, error) {
+	var matched bool
+	err := lc.retry(true, func() error {
+		err := lc.redial()
+		if err != nil {
+			return err
+		}
 
-	return lc.Conn.Add(addRequest)
+		start := time.Now()
+		matched, err = lc.Conn.Compare(dn, attribute, value)
+		lc.trace("compare", fmt.Sprintf("dn=%q attribute=%s", dn, attribute), start, err)
+		lc.failover(err)
+		return err
+	})
+	return matched, err
 }
 
-// ChangeMembers updates the members of a given group.
-func (lc *LDAPClient) ChangeMembers(members []string, groupname, ou string) error {
-	DN := fmt.Sprintf("cn=%s,ou=%s,%s", groupname, ou, lc.Base)
-	return lc.ChangeAttribute(DN, "memberUid", members)
+// add runs an add request on the connection.
+func (lc *LDAPClient) add(addRequest *ldap.AddRequest) error {
+	addRequest.Controls = lc.controls(addRequest.Controls)
+	return lc.write("add", fmt.Sprintf("dn=%q", addRequest.DN), addRequest, func() error {
+		return lc.Conn.Add(addRequest)
+	})
 }
 
-// ChangeDescription updates the description of a given OU.
-func (lc *LDAPClient) ChangeDescription(description, ou string) error {
-	DN := fmt.Sprintf("ou=%s,%s", ou, lc.Base)
-	return lc.ChangeAttribute(DN, "description", []string{description})
+// modify runs a modify request on the connection.
+func (lc *LDAPClient) modify(modifyRequest *ldap.ModifyRequest) error {
+	modifyRequest.Controls = lc.controls(modifyRequest.Controls)
+	return lc.write("modify", fmt.Sprintf("dn=%q", modifyRequest.DN), modifyRequest, func() error {
+		return lc.Conn.Modify(modifyRequest)
+	})
 }
 
-// ChangePassword updates the password of a given user.
-func (lc *LDAPClient) ChangePassword(password, username, ou string) error {
-	DN := fmt.Sprintf("cn=%s,ou=%s,%s", username, ou, lc.Base)
-	return lc.ChangeAttribute(DN, "userPassword", []string{password})
+// del runs a delete request on the connection.
+func (lc *LDAPClient) del(delRequest *ldap.DelRequest) error {
+	delRequest.Controls = lc.controls(delRequest.Controls)
+	return lc.write("delete", fmt.Sprintf("dn=%q", delRequest.DN), delRequest, func() error {
+		return lc.Conn.Del(delRequest)
+	})
 }
 
-// ChangeAttribute updates the attribute values of a given DN.
-func (lc *LDAPClient) ChangeAttribute(DN, attribute string, values []string) error {
-	err := lc.Connect()
-	if err != nil {
+// modifyDN runs a modify DN request on the connection.
+func (lc *LDAPClient) modifyDN(modifyDNRequest *ldap.ModifyDNRequest) error {
+	modifyDNRequest.Controls = lc.controls(modifyDNRequest.Controls)
+	return lc.write("modifyDN", fmt.Sprintf("dn=%q", modifyDNRequest.DN), modifyDNRequest, func() error {
+		return lc.Conn.ModifyDN(modifyDNRequest)
+	})
+}
+
+// passwordModify runs a password modify extended operation on the connection,
+// with the given controls, which ldap.PasswordModifyRequest does not carry.
+func (lc *LDAPClient) passwordModify(passwordModifyRequest *ldap.PasswordModifyRequest, controls ...ldap.Control) error {
+	extendedRequest := ldap.NewExtendedRequest(passwordModifyOID, passwordModifyValue(passwordModifyRequest))
+	extendedRequest.Controls = lc.controls(controls)
+	return lc.write("passwordModify", fmt.Sprintf("user=%q", passwordModifyRequest.UserIdentity), passwordModifyRequest, func() error {
+		_, err := lc.Conn.Extended(extendedRequest)
 		return err
+	})
+}
+
+// write runs op, a write request described by details, with fn on the
+// connection, or hands the request to dryRun, and clears the search cache.
+// It is only retried when the server did not perform it, or when the request
+// could not be sent.
+func (lc *LDAPClient) write(op, details string, request interface{}, fn func() error) error {
+	if lc.dryRun(op, details, request) {
+		return nil
 	}
 
-	// First bind with an admin user
-	if lc.BindDN != "" && lc.BindPassword != "" {
-		err := lc.Conn.Bind(lc.BindDN, lc.BindPassword)
+	defer lc.invalidateCache()
+
+	return lc.retry(false, func() error {
+		err := lc.redial()
 		if err != nil {
-			return err
+			// The request was not sent, so it can be retried
+			return &unsentError{err}
+		}
+
+		start := time.Now()
+		err = fn()
+		lc.trace(op, details, start, err)
+		lc.failover(err)
+		return err
+	})
+}
+
+// controls returns the given request controls along with the ones enabled
+// on the client, unless already present, e.g. when a request is resent.
+func (lc *LDAPClient) controls(controls []ldap.Control) []ldap.Control {
+	enabled := lc.Controls
+	if lc.ManageDsaIT {
+		enabled = append(enabled[:len(enabled):len(enabled)], ldap.NewControlManageDsaIT(true))
+	}
+	if lc.ProxiedAuthzID != "" {
+		enabled = append(enabled[:len(enabled):len(enabled)], NewControlProxiedAuthz(lc.ProxiedAuthzID))
+	}
+
+	// Never write to the backing array of the caller
+	controls = controls[:len(controls):len(controls)]
+	for _, control := range enabled {
+		if ldap.FindControl(controls, control.GetControlType()) == nil {
+			controls = append(controls, control)
 		}
 	}
+	return controls
+}
+
+// NewControlProxiedAuthz returns a critical RFC 4370 proxied authorization
+// control for authzID, e.g. "dn:uid=alice,ou=people,dc=example,dc=com" or
+// "u:alice", to attach to a single request.
+func NewControlProxiedAuthz(authzID string) ldap.Control {
+	return ldap.NewControlString(controlTypeProxiedAuthz, true, authzID)
+}
 
-	modifyRequest := ldap.NewModifyRequest(DN)
-	attr := ldap.PartialAttribute{
-		Type: attribute,
-		Vals: values,
+// dryRun reports whether DryRun is set, in which case it logs the request
+// and hands it to OnDryRun instead of sending it.
+func (lc *LDAPClient) dryRun(op, details string, request interface{}) bool {
+	if !lc.DryRun {
+		return false
+	}
+	if lc.OnDryRun != nil {
+		lc.OnDryRun(request)
 	}
-	attributes := []ldap.PartialAttribute{}
-	modifyRequest.ReplaceAttributes = append(attributes, attr)
+	if lc.Logger != nil {
+		lc.Logger.Printf("ldap: dry run %s %s", op, details)
+	}
+	return true
+}
 
-	return lc.Conn.Modify(modifyRequest)
+// trace reports an operation started at start to the Observe function and
+// the Logger, if any. The details must not contain any password.
+func (lc *LDAPClient) trace(op, details string, start time.Time, err error) {
+	duration := time.Since(start)
+	if lc.Observe != nil {
+		lc.Observe(op, duration, err)
+	}
+	if lc.Logger == nil {
+		return
+	}
+	if err != nil {
+		lc.Logger.Printf("ldap: %s %s failed after %s: %v", op, details, duration, err)
+		return
+	}
+	lc.Logger.Printf("ldap: %s %s took %s", op, details, duration)
 }
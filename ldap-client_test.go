@@ -0,0 +1,1346 @@
+package ldap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		host     string
+		port     int
+		expected string
+	}{
+		{"ldap.example.com", 389, "ldap.example.com:389"},
+		{"10.0.0.1", 636, "10.0.0.1:636"},
+		{"::1", 389, "[::1]:389"},
+		{"2001:db8::1", 636, "[2001:db8::1]:636"},
+	}
+
+	for _, test := range tests {
+		lc := &LDAPClient{Port: test.port}
+		if address := lc.address(test.host); address != test.expected {
+			t.Errorf("expected %s for %s, got %s", test.expected, test.host, address)
+		}
+	}
+}
+
+func TestDefaultPort(t *testing.T) {
+	tests := []struct {
+		port     int
+		useSSL   bool
+		expected string
+	}{
+		{0, false, "ldap.example.com:389"},
+		{0, true, "ldap.example.com:636"},
+		{1389, false, "ldap.example.com:1389"},
+		{1636, true, "ldap.example.com:1636"},
+	}
+
+	for _, test := range tests {
+		lc := &LDAPClient{Port: test.port, UseSSL: test.useSSL}
+		if address := lc.address("ldap.example.com"); address != test.expected {
+			t.Errorf("expected %s for port %d and SSL %v, got %s", test.expected, test.port, test.useSSL, address)
+		}
+	}
+}
+
+type countingDialer struct {
+	net.Dialer
+	dials int
+}
+
+func (d *countingDialer) Dial(network, address string) (net.Conn, error) {
+	d.dials++
+	return d.Dialer.Dial(network, address)
+}
+
+func TestDialer(t *testing.T) {
+	listener, port := listen(t)
+	defer listener.Close()
+
+	dialer := &countingDialer{}
+	lc := &LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true, Dialer: dialer}
+	if err := lc.Connect(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lc.Close()
+	if dialer.dials != 1 {
+		t.Errorf("expected the dialer to be used once, got %d", dialer.dials)
+	}
+
+	// Dialing errors are network errors, to fail over and retry
+	lc = &LDAPClient{Host: "127.0.0.1", Port: deadPort(t), SkipTLS: true, Dialer: dialer}
+	if err := lc.Connect(); !ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		t.Errorf("expected a network error, got %v", err)
+	}
+}
+
+func TestConnectIPv6(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	defer listener.Close()
+
+	lc := &LDAPClient{
+		Host:    "::1",
+		Port:    listener.Addr().(*net.TCPAddr).Port,
+		SkipTLS: true,
+	}
+	if err := lc.Connect(); err != nil {
+		t.Fatalf("expected to connect to %s, got %v", lc.address(lc.Host), err)
+	}
+	lc.Close()
+}
+
+// redirectDialer dials the addresses it maps to another one there instead.
+type redirectDialer map[string]string
+
+func (d redirectDialer) Dial(network, address string) (net.Conn, error) {
+	if target, ok := d[address]; ok {
+		address = target
+	}
+	return net.Dial(network, address)
+}
+
+func TestConnectFailover(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// The first host refuses connections, as it is redirected to a dead port
+	port := listener.Addr().(*net.TCPAddr).Port
+	lc := &LDAPClient{
+		Hosts:   []string{"dead.example.com", "127.0.0.1"},
+		Port:    port,
+		SkipTLS: true,
+		Dialer: redirectDialer{
+			net.JoinHostPort("dead.example.com", strconv.Itoa(port)): net.JoinHostPort("127.0.0.1", strconv.Itoa(deadPort(t))),
+		},
+	}
+	if err := lc.Connect(); err != nil {
+		t.Fatalf("expected to fail over to 127.0.0.1, got %v", err)
+	}
+	defer lc.Close()
+	if lc.hostIndex != 1 {
+		t.Errorf("expected to remember host 1, got %d", lc.hostIndex)
+	}
+}
+
+func TestDiffValues(t *testing.T) {
+	tests := []struct {
+		current, target, added, removed []string
+	}{
+		{[]string{"alice", "bob"}, []string{"alice", "bob"}, nil, nil},
+		{[]string{"alice", "bob"}, []string{"alice", "carol"}, []string{"carol"}, []string{"bob"}},
+		{nil, []string{"alice", "alice"}, []string{"alice"}, nil},
+		{[]string{"alice", "bob"}, nil, nil, []string{"alice", "bob"}},
+	}
+
+	for _, test := range tests {
+		added, removed := diffValues(test.current, test.target)
+		if !reflect.DeepEqual(added, test.added) || !reflect.DeepEqual(removed, test.removed) {
+			t.Errorf("expected +%v -%v for %v -> %v, got +%v -%v",
+				test.added, test.removed, test.current, test.target, added, removed)
+		}
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		referral string
+		host     string
+		port     int
+		useSSL   bool
+		base     string
+	}{
+		{"ldap://dc2.example.com/DC=child,DC=example,DC=com", "dc2.example.com", 389, false, "DC=child,DC=example,DC=com"},
+		{"ldaps://dc2.example.com/DC=child,DC=example,DC=com", "dc2.example.com", 636, true, "DC=child,DC=example,DC=com"},
+		{"ldap://dc2.example.com:3268/", "dc2.example.com", 3268, false, ""},
+		{"ldap://[::1]:1389/ou=People%2Cdc=example", "::1", 1389, false, "ou=People,dc=example"},
+	}
+
+	for _, test := range tests {
+		host, port, useSSL, base, err := parseURL(test.referral)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.referral, err)
+		}
+		if host != test.host || port != test.port || useSSL != test.useSSL || base != test.base {
+			t.Errorf("unexpected %s, %d, %t, %s for %s", host, port, useSSL, base, test.referral)
+		}
+	}
+
+	if _, _, _, _, err := parseURL("http://example.com/"); err == nil {
+		t.Errorf("expected an error for a non ldap URL")
+	}
+}
+
+func TestApplyURL(t *testing.T) {
+	lc := &LDAPClient{URL: "ldaps://ldap.example.com", Host: "other.example.com", Port: 389}
+	if err := lc.applyURL(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lc.Host != "ldap.example.com" || lc.Port != 636 || !lc.UseSSL || lc.ServerName != "ldap.example.com" {
+		t.Errorf("unexpected %s, %d, %t, %s", lc.Host, lc.Port, lc.UseSSL, lc.ServerName)
+	}
+
+	lc = &LDAPClient{URL: "ldap://ldap.example.com:1389", ServerName: "ldap"}
+	if err := lc.applyURL(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lc.Host != "ldap.example.com" || lc.Port != 1389 || lc.UseSSL || lc.ServerName != "ldap" {
+		t.Errorf("unexpected %s, %d, %t, %s", lc.Host, lc.Port, lc.UseSSL, lc.ServerName)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		lc    *LDAPClient
+		valid bool
+	}{
+		{&LDAPClient{Port: 389}, true},
+		{&LDAPClient{Port: 389, SkipTLS: true}, true},
+		{&LDAPClient{Port: 636, UseSSL: true}, true},
+		{&LDAPClient{Port: 636, UseSSL: true, SkipTLS: true}, false},
+		{&LDAPClient{Port: 65536}, false},
+	}
+
+	for _, test := range tests {
+		err := test.lc.validate()
+		if test.valid && err != nil {
+			t.Errorf("unexpected error for %+v: %v", test.lc, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected an error for %+v", test.lc)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	lc := &LDAPClient{}
+	if config := lc.tlsConfig("ldap.example.com"); config.ServerName != "ldap.example.com" || config.InsecureSkipVerify {
+		t.Errorf("expected verification against the host, got %+v", config)
+	}
+
+	lc = &LDAPClient{ServerName: "ldap", InsecureSkipVerify: true}
+	if config := lc.tlsConfig("ldap.example.com"); config.ServerName != "ldap" || !config.InsecureSkipVerify {
+		t.Errorf("expected the configured settings, got %+v", config)
+	}
+
+	lc = &LDAPClient{TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}}
+	config := lc.tlsConfig("ldap.example.com")
+	if config.ServerName != "ldap.example.com" || config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected the given configuration, got %+v", config)
+	}
+	if lc.TLSConfig.ServerName != "" {
+		t.Errorf("expected the given configuration to be left untouched")
+	}
+
+	// StartTLS may be verified differently than LDAPS
+	lc = &LDAPClient{StartTLSInsecure: true}
+	if config := lc.tlsConfig("ldap.example.com"); config.InsecureSkipVerify {
+		t.Errorf("expected LDAPS to be verified, got %+v", config)
+	}
+	if config := lc.startTLSConfig("ldap.example.com"); !config.InsecureSkipVerify {
+		t.Errorf("expected StartTLS not to be verified, got %+v", config)
+	}
+	lc = &LDAPClient{TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}, StartTLSConfig: &tls.Config{MinVersion: tls.VersionTLS13}}
+	if config := lc.startTLSConfig("ldap.example.com"); config.MinVersion != tls.VersionTLS13 || config.ServerName != "ldap.example.com" {
+		t.Errorf("expected the StartTLS configuration, got %+v", config)
+	}
+}
+
+// selfSignedCertificate returns a certificate for 127.0.0.1 and dnsNames.
+func selfSignedCertificate(t *testing.T, dnsNames ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	lc := &LDAPClient{MinTLSVersion: tls.VersionTLS12, TLSConfig: &tls.Config{MinVersion: tls.VersionTLS10}}
+	if config := lc.startTLSConfig("ldap.example.com"); config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 at least, got %x", config.MinVersion)
+	}
+
+	// A TLS 1.0 and 1.1 only server
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCertificate(t)},
+		MinVersion:   tls.VersionTLS10,
+		MaxVersion:   tls.VersionTLS11,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	// is accepted by a client allowing TLS 1.0
+	lc = &LDAPClient{
+		Host:               "127.0.0.1",
+		Port:               listener.Addr().(*net.TCPAddr).Port,
+		UseSSL:             true,
+		InsecureSkipVerify: true,
+		TLSConfig:          &tls.Config{MinVersion: tls.VersionTLS10},
+	}
+	if err := lc.Connect(); err != nil {
+		t.Fatalf("expected the handshake to succeed without MinTLSVersion, got %v", err)
+	}
+	lc.Close()
+
+	// unless MinTLSVersion requires TLS 1.2
+	lc.MinTLSVersion = tls.VersionTLS12
+	if err := lc.Connect(); err == nil {
+		lc.Close()
+		t.Errorf("expected the handshake to fail")
+	}
+}
+
+func TestStartTLSServerName(t *testing.T) {
+	certificate := selfSignedCertificate(t, "ldap.internal")
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(parsed)
+
+	// Dialing one name while the certificate is for another, behind an SNI
+	// router
+	lc := &LDAPClient{ServerName: "ldap.internal", StartTLSConfig: &tls.Config{RootCAs: roots, ServerName: "ignored"}}
+	config := lc.startTLSConfig("10.0.0.1")
+	if config.ServerName != "ldap.internal" {
+		t.Fatalf("expected ldap.internal, got %q", config.ServerName)
+	}
+
+	// Upgrade a plain connection like StartTLS does
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	sni := make(chan string, 1)
+	go func() {
+		server := tls.Server(serverConn, &tls.Config{
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				sni <- hello.ServerName
+				return nil, nil
+			},
+			Certificates: []tls.Certificate{certificate},
+		})
+		server.Handshake()
+		server.Close()
+	}()
+
+	client := tls.Client(clientConn, config)
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("expected the certificate to be verified against the server name: %v", err)
+	}
+	if name := <-sni; name != "ldap.internal" {
+		t.Errorf("expected SNI ldap.internal, got %q", name)
+	}
+}
+
+func TestUserDN(t *testing.T) {
+	lc := &LDAPClient{Base: "dc=example,dc=com"}
+	if dn := lc.userDN("Smith, John", "people"); dn != `cn=Smith\, John,ou=people,dc=example,dc=com` {
+		t.Errorf("unexpected DN %s", dn)
+	}
+
+	lc.UserDNTemplate = "uid=%s,ou=%s,%s"
+	if dn := lc.userDN("jsmith", "people"); dn != "uid=jsmith,ou=people,dc=example,dc=com" {
+		t.Errorf("unexpected DN %s", dn)
+	}
+}
+
+func TestUserFilterEscaping(t *testing.T) {
+	lc := &LDAPClient{UserFilter: "(uid=%s)", GroupFilter: "(memberUid=%s)"}
+
+	if filter := lc.userFilter("alice"); filter != "(uid=alice)" {
+		t.Errorf("unexpected filter %s", filter)
+	}
+
+	// A malicious username must not turn the filter into a wildcard match
+	if filter := lc.userFilter("*)(uid=*"); filter != `(uid=\2a\29\28uid=\2a)` {
+		t.Errorf("unexpected filter %s", filter)
+	}
+	if filter := lc.userFilter("*"); filter != `(uid=\2a)` {
+		t.Errorf("unexpected filter %s", filter)
+	}
+	if filter := lc.groupFilter("a)(|(memberUid=*"); filter != `(memberUid=a\29\28|\28memberUid=\2a)` {
+		t.Errorf("unexpected filter %s", filter)
+	}
+}
+
+func TestNewModifyRequest(t *testing.T) {
+	modifyRequest := newModifyRequest("cn=alice,dc=example,dc=com",
+		map[string][]string{"mail": {"alice@example.com"}, "cn": {"Alice"}},
+		map[string][]string{"telephoneNumber": {}},
+		map[string][]string{"sn": {"Smith"}},
+	)
+
+	expected := []ldap.Change{
+		{Operation: ldap.AddAttribute, Modification: ldap.PartialAttribute{Type: "cn", Vals: []string{"Alice"}}},
+		{Operation: ldap.AddAttribute, Modification: ldap.PartialAttribute{Type: "mail", Vals: []string{"alice@example.com"}}},
+		{Operation: ldap.DeleteAttribute, Modification: ldap.PartialAttribute{Type: "telephoneNumber", Vals: []string{}}},
+		{Operation: ldap.ReplaceAttribute, Modification: ldap.PartialAttribute{Type: "sn", Vals: []string{"Smith"}}},
+	}
+	if !reflect.DeepEqual(modifyRequest.Changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, modifyRequest.Changes)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	var requests []interface{}
+	lc := &LDAPClient{
+		DryRun:   true,
+		OnDryRun: func(request interface{}) { requests = append(requests, request) },
+	}
+
+	// Without a connection, the requests would fail if they were sent
+	modifyRequest := ldap.NewModifyRequest("cn=admins,ou=groups,dc=example,dc=com", nil)
+	modifyRequest.Replace("memberUid", []string{"alice"})
+	if err := lc.modify(modifyRequest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	delRequest := ldap.NewDelRequest("cn=admins,ou=groups,dc=example,dc=com", nil)
+	if err := lc.del(delRequest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []interface{}{modifyRequest, delRequest}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected %+v, got %+v", expected, requests)
+	}
+}
+
+func TestSetBinaryAttribute(t *testing.T) {
+	listener, port := listen(t)
+	defer listener.Close()
+
+	var requests []interface{}
+	lc := &LDAPClient{
+		Host:     "127.0.0.1",
+		Port:     port,
+		SkipTLS:  true,
+		DryRun:   true,
+		OnDryRun: func(request interface{}) { requests = append(requests, request) },
+	}
+	defer lc.Close()
+
+	// Not valid UTF-8
+	photo := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10}
+	if err := lc.SetBinaryAttribute("uid=alice,ou=people,dc=example,dc=com", "jpegPhoto", photo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("expected a modify request, got %+v", requests)
+	}
+	vals := requests[0].(*ldap.ModifyRequest).Changes[0].Modification.Vals
+	if len(vals) != 1 || vals[0] != string(photo) {
+		t.Errorf("expected the photo bytes unchanged, got %q", vals)
+	}
+}
+
+func TestRenameGroup(t *testing.T) {
+	listener, port := listen(t)
+	defer listener.Close()
+
+	var requests []interface{}
+	lc := &LDAPClient{
+		Host:     "127.0.0.1",
+		Port:     port,
+		SkipTLS:  true,
+		Base:     "dc=example,dc=com",
+		DryRun:   true,
+		OnDryRun: func(request interface{}) { requests = append(requests, request) },
+	}
+	defer lc.Close()
+
+	if err := lc.RenameGroup("admins", "Admins, EU", "groups"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []interface{}{ldap.NewModifyDNRequest("cn=admins,ou=groups,dc=example,dc=com", `cn=Admins\, EU`, true, "")}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected %+v, got %+v", expected, requests)
+	}
+}
+
+func TestManageDsaIT(t *testing.T) {
+	lc := &LDAPClient{ManageDsaIT: true}
+
+	controls := lc.controls(nil)
+	if len(controls) != 1 || controls[0].GetControlType() != ldap.ControlTypeManageDsaIT {
+		t.Fatalf("expected the ManageDsaIT control, got %+v", controls)
+	}
+	if again := lc.controls(controls); len(again) != 1 {
+		t.Errorf("expected the control to be attached once, got %+v", again)
+	}
+	if controls := (&LDAPClient{}).controls(nil); len(controls) != 0 {
+		t.Errorf("expected no control, got %+v", controls)
+	}
+}
+
+func TestControls(t *testing.T) {
+	treeDelete := ldap.NewControlString("1.2.840.113556.1.4.805", true, "")
+	paging := ldap.NewControlString("1.2.840.113556.1.4.319", false, "")
+	lc := &LDAPClient{Controls: []ldap.Control{treeDelete}, ManageDsaIT: true}
+
+	requested := []ldap.Control{paging}
+	controls := lc.controls(requested)
+	if len(controls) != 3 || controls[0] != paging || controls[1] != treeDelete ||
+		controls[2].GetControlType() != ldap.ControlTypeManageDsaIT {
+		t.Errorf("unexpected controls %+v", controls)
+	}
+	if len(lc.Controls) != 1 || len(requested) != 1 {
+		t.Errorf("expected the given controls to be left untouched")
+	}
+}
+
+func TestProxiedAuthzID(t *testing.T) {
+	lc := &LDAPClient{ProxiedAuthzID: "dn:uid=alice,ou=people,dc=example,dc=com"}
+
+	controls := lc.controls(nil)
+	expected := []ldap.Control{ldap.NewControlString(controlTypeProxiedAuthz, true, "dn:uid=alice,ou=people,dc=example,dc=com")}
+	if !reflect.DeepEqual(controls, expected) {
+		t.Errorf("expected %+v, got %+v", expected, controls)
+	}
+}
+
+func TestUserAccountEntry(t *testing.T) {
+	lc := &LDAPClient{Base: "dc=example,dc=com"}
+
+	dn, attributes := lc.userAccountEntry(AddUserAccount{
+		Username:         "alice",
+		OU:               "people",
+		ObjectClasses:    []string{"PosixAccount", "extensibleObject"},
+		ShadowLastChange: 19000,
+	})
+	if dn != "cn=alice,ou=people,dc=example,dc=com" {
+		t.Errorf("unexpected DN %s", dn)
+	}
+	expected := []string{"inetOrgPerson", "posixAccount", "extensibleObject", "shadowAccount"}
+	if !reflect.DeepEqual(attributes["objectClass"], expected) {
+		t.Errorf("expected %v, got %v", expected, attributes["objectClass"])
+	}
+	if !reflect.DeepEqual(attributes["shadowLastChange"], []string{"19000"}) {
+		t.Errorf("unexpected shadowLastChange %v", attributes["shadowLastChange"])
+	}
+	if _, ok := attributes["shadowMax"]; ok {
+		t.Errorf("unexpected shadowMax %v", attributes["shadowMax"])
+	}
+
+	_, attributes = lc.userAccountEntry(AddUserAccount{Username: "bob", OU: "people"})
+	if !reflect.DeepEqual(attributes["objectClass"], defaultUserAccountObjectClasses) {
+		t.Errorf("expected %v, got %v", defaultUserAccountObjectClasses, attributes["objectClass"])
+	}
+	if attributes["homeDirectory"][0] != "/home/bob" || attributes["loginShell"][0] != "/bin/bash" {
+		t.Errorf("unexpected home directory %v and login shell %v", attributes["homeDirectory"], attributes["loginShell"])
+	}
+
+	_, attributes = lc.userAccountEntry(AddUserAccount{Username: "backup", OU: "services", HomeDirectory: "/var/backups", LoginShell: "/sbin/nologin"})
+	if attributes["homeDirectory"][0] != "/var/backups" || attributes["loginShell"][0] != "/sbin/nologin" {
+		t.Errorf("unexpected home directory %v and login shell %v", attributes["homeDirectory"], attributes["loginShell"])
+	}
+
+	// Extra attributes win over the default ones
+	_, attributes = lc.userAccountEntry(AddUserAccount{
+		Username: "carol",
+		OU:       "people",
+		Extra: map[string][]string{
+			"mail":       {"carol@example.com"},
+			"loginShell": {"/bin/zsh"},
+		},
+	})
+	if !reflect.DeepEqual(attributes["mail"], []string{"carol@example.com"}) || !reflect.DeepEqual(attributes["loginShell"], []string{"/bin/zsh"}) {
+		t.Errorf("unexpected mail %v and login shell %v", attributes["mail"], attributes["loginShell"])
+	}
+	if !reflect.DeepEqual(attributes["uid"], []string{"carol"}) {
+		t.Errorf("unexpected uid %v", attributes["uid"])
+	}
+}
+
+func TestReuseAfterClose(t *testing.T) {
+	var readerBinds int
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			if dn, _ := bindRequest(request); dn == "cn=reader,dc=example,dc=com" {
+				readerBinds++
+			}
+		case ldap.ApplicationSearchRequest:
+			return []*ber.Packet{
+				ldapEntry(id, "uid=alice,dc=example,dc=com", nil),
+				ldapResult(id, request, ldap.LDAPResultSuccess),
+			}
+		}
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+	})
+
+	var ops []string
+	lc := &LDAPClient{
+		Host:         "127.0.0.1",
+		Port:         port,
+		SkipTLS:      true,
+		Base:         "dc=example,dc=com",
+		UserFilter:   "(uid=%s)",
+		BindDN:       "cn=reader,dc=example,dc=com",
+		BindPassword: "secret",
+		Observe: func(op string, duration time.Duration, err error) {
+			ops = append(ops, op)
+		},
+	}
+	if err := lc.reconnect(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn := lc.Conn
+
+	// The current connection is kept until closed
+	if err := lc.reconnect(); err != nil || lc.Conn != conn {
+		t.Fatalf("expected the connection to be kept, got %v", err)
+	}
+
+	lc.Close()
+	lc.Close()
+	ops, readerBinds = nil, 0
+	ok, user, err := lc.Authenticate("alice", "password")
+	defer lc.Close()
+	if !ok || err != nil || user["dn"] != "uid=alice,dc=example,dc=com" {
+		t.Fatalf("expected to authenticate after Close, got %v, %v, %v", ok, user, err)
+	}
+	if lc.Conn == nil || lc.Conn == conn {
+		t.Errorf("expected a new connection after Close")
+	}
+	expected := []string{"connect", "bind", "search", "bind", "bind"}
+	if !reflect.DeepEqual(ops, expected) || readerBinds != 2 {
+		t.Errorf("expected %v with 2 binds as the read only user, got %v with %d", expected, ops, readerBinds)
+	}
+	if lc.boundDN != lc.BindDN {
+		t.Errorf("expected to be bound back as the read only user, got %q", lc.boundDN)
+	}
+}
+
+func TestLeavesFirst(t *testing.T) {
+	dns := leavesFirst([]string{
+		"ou=tenant,dc=example,dc=com",
+		"ou=people,ou=tenant,dc=example,dc=com",
+		"cn=alice,ou=people,ou=tenant,dc=example,dc=com",
+		"cn=admins,ou=tenant,dc=example,dc=com",
+	})
+
+	expected := []string{
+		"cn=alice,ou=people,ou=tenant,dc=example,dc=com",
+		"ou=people,ou=tenant,dc=example,dc=com",
+		"cn=admins,ou=tenant,dc=example,dc=com",
+		"ou=tenant,dc=example,dc=com",
+	}
+	if !reflect.DeepEqual(dns, expected) {
+		t.Errorf("expected %v, got %v", expected, dns)
+	}
+}
+
+func TestDeleteTreePaged(t *testing.T) {
+	var deleted []string
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		switch request.Tag {
+		case ldap.ApplicationSearchRequest:
+			// The root DSE advertises no subtree delete control
+			if request.Children[0].Data.String() == "" {
+				return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+			}
+			paging, ok := ldap.FindControl(controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+			if !ok {
+				return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSizeLimitExceeded)}
+			}
+			if len(paging.Cookie) == 0 {
+				next := ldap.NewControlPaging(paging.PagingSize)
+				next.SetCookie([]byte("next"))
+				return []*ber.Packet{
+					ldapEntry(id, "ou=tenant,dc=example,dc=com", nil),
+					ldapEntry(id, "ou=people,ou=tenant,dc=example,dc=com", nil),
+					ldapResult(id, request, ldap.LDAPResultSuccess, next),
+				}
+			}
+			return []*ber.Packet{
+				ldapEntry(id, "cn=alice,ou=people,ou=tenant,dc=example,dc=com", nil),
+				ldapResult(id, request, ldap.LDAPResultSuccess, ldap.NewControlPaging(paging.PagingSize)),
+			}
+		case ldap.ApplicationDelRequest:
+			deleted = append(deleted, request.Data.String())
+		}
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+	})
+
+	lc := &LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true, PageSize: 2}
+	defer lc.Close()
+
+	if err := lc.DeleteTree("ou=tenant,dc=example,dc=com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"cn=alice,ou=people,ou=tenant,dc=example,dc=com",
+		"ou=people,ou=tenant,dc=example,dc=com",
+		"ou=tenant,dc=example,dc=com",
+	}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("expected %v, got %v", expected, deleted)
+	}
+}
+
+func TestNewControlAssertion(t *testing.T) {
+	control, err := NewControlAssertion("(memberUid=alice)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if control.GetControlType() != controlTypeAssertion {
+		t.Errorf("unexpected control type %s", control.GetControlType())
+	}
+
+	if _, err := NewControlAssertion("memberUid=alice)"); err == nil {
+		t.Errorf("expected an invalid filter to fail")
+	}
+}
+
+func TestModifyIfUnchanged(t *testing.T) {
+	var assertions []string
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		if request.Tag != ldap.ApplicationModifyRequest {
+			return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+		}
+		control, ok := ldap.FindControl(controls, controlTypeAssertion).(*ldap.ControlString)
+		if !ok {
+			return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+		}
+		filter, err := ldap.DecompileFilter(ber.DecodePacket([]byte(control.ControlValue)))
+		if err != nil {
+			return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultProtocolError)}
+		}
+		assertions = append(assertions, filter)
+		if filter != "(modifyTimestamp=20260101000000Z)" {
+			return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultAssertionFailed)}
+		}
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+	})
+
+	lc := &LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true}
+	defer lc.Close()
+
+	replace := map[string][]string{"description": {"updated"}}
+	if err := lc.ModifyIfUnchanged("uid=alice,dc=example,dc=com", "20260101000000Z", nil, nil, replace); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := lc.ModifyIfUnchanged("uid=alice,dc=example,dc=com", "20250101000000Z", nil, nil, replace); err != ErrEntryChanged {
+		t.Errorf("expected ErrEntryChanged, got %v", err)
+	}
+	expected := []string{"(modifyTimestamp=20260101000000Z)", "(modifyTimestamp=20250101000000Z)"}
+	if !reflect.DeepEqual(assertions, expected) {
+		t.Errorf("expected the assertions %v, got %v", expected, assertions)
+	}
+}
+
+func TestSplitRDN(t *testing.T) {
+	tests := []struct {
+		dn, rdn, parent string
+	}{
+		{"cn=alice,ou=people,dc=example,dc=com", "cn=alice", "ou=people,dc=example,dc=com"},
+		{`cn=Smith\, John,ou=people,dc=example,dc=com`, `cn=Smith\, John`, "ou=people,dc=example,dc=com"},
+		{`cn=trailing\\,dc=com`, `cn=trailing\\`, "dc=com"},
+		{"dc=com", "dc=com", ""},
+	}
+	for _, test := range tests {
+		rdn, parent := splitRDN(test.dn)
+		if rdn != test.rdn || parent != test.parent {
+			t.Errorf("expected %q and %q for %q, got %q and %q", test.rdn, test.parent, test.dn, rdn, parent)
+		}
+	}
+}
+
+func TestBindUserName(t *testing.T) {
+	tests := []struct {
+		template, username, name string
+	}{
+		{"uid=%s,ou=people,dc=example,dc=com", "alice", "uid=alice,ou=people,dc=example,dc=com"},
+		{"cn=%s,dc=example,dc=com", "Smith, John", `cn=Smith\, John,dc=example,dc=com`},
+		{"%s@example.com", "alice", "alice@example.com"},
+		{"%s@example.com", "o'brien", "o'brien@example.com"},
+	}
+	for _, test := range tests {
+		lc := &LDAPClient{BindUserDNTemplate: test.template}
+		if name := lc.bindUserName(test.username); name != test.name {
+			t.Errorf("expected %q for %q, got %q", test.name, test.template, name)
+		}
+	}
+}
+
+func TestUserAttributes(t *testing.T) {
+	attributes := make([]string, 1, 4)
+	attributes[0] = "mail"
+	if searched := userAttributes(attributes, true, "dn"); !reflect.DeepEqual(searched, []string{"mail", "dn", "memberOf"}) {
+		t.Errorf("expected memberOf to be searched, got %v", searched)
+	}
+	if searched := userAttributes(attributes, false); !reflect.DeepEqual(searched, []string{"mail"}) {
+		t.Errorf("expected only mail to be searched, got %v", searched)
+	}
+
+	// The slice of the caller is left untouched despite its capacity
+	if attributes[:2][1] != "" {
+		t.Errorf("expected the attributes not to be appended to, got %v", attributes[:2])
+	}
+}
+
+func TestSearchBases(t *testing.T) {
+	lc := &LDAPClient{Base: "dc=example,dc=com"}
+	if bases := lc.searchBases(); !reflect.DeepEqual(bases, []string{"dc=example,dc=com"}) {
+		t.Errorf("expected the base, got %v", bases)
+	}
+	lc.SearchBases = []string{"ou=people,dc=example,dc=com", "ou=contractors,dc=example,dc=com"}
+	if bases := lc.searchBases(); !reflect.DeepEqual(bases, lc.SearchBases) {
+		t.Errorf("expected the search bases, got %v", bases)
+	}
+
+	seen := map[string]bool{}
+	entries := appendUniqueEntries(nil, []*ldap.Entry{
+		{DN: "uid=alice,ou=people,dc=example,dc=com"},
+		{DN: "uid=bob,ou=people,dc=example,dc=com"},
+	}, seen)
+	entries = appendUniqueEntries(entries, []*ldap.Entry{
+		{DN: "UID=Alice, ou=People,dc=example,dc=com"},
+		{DN: "uid=carol,ou=contractors,dc=example,dc=com"},
+	}, seen)
+	if len(entries) != 3 || entries[2].DN != "uid=carol,ou=contractors,dc=example,dc=com" {
+		t.Errorf("expected alice once, got %+v", entries)
+	}
+}
+
+func TestFilterSearchBases(t *testing.T) {
+	entries := map[string][]string{
+		"ou=people,dc=example,dc=com":      {"uid=alice,ou=people,dc=example,dc=com", "uid=bob,ou=people,dc=example,dc=com"},
+		"ou=contractors,dc=example,dc=com": {"uid=alice,ou=people,dc=example,dc=com", "uid=carol,ou=contractors,dc=example,dc=com"},
+	}
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		var responses []*ber.Packet
+		if request.Tag == ldap.ApplicationSearchRequest {
+			for _, dn := range entries[request.Children[0].Data.String()] {
+				responses = append(responses, ldapEntry(id, dn, nil))
+			}
+		}
+		return append(responses, ldapResult(id, request, ldap.LDAPResultSuccess))
+	})
+
+	lc := &LDAPClient{
+		Host:        "127.0.0.1",
+		Port:        port,
+		SkipTLS:     true,
+		SearchBases: []string{"ou=people,dc=example,dc=com", "ou=contractors,dc=example,dc=com"},
+	}
+	defer lc.Close()
+
+	expected := []string{
+		"uid=alice,ou=people,dc=example,dc=com",
+		"uid=bob,ou=people,dc=example,dc=com",
+		"uid=carol,ou=contractors,dc=example,dc=com",
+	}
+	var found []string
+	err := lc.FilterEach("(objectClass=person)", []string{"dn"}, func(entry *ldap.Entry) error {
+		found = append(found, entry.DN)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, got %v and %v", expected, found, err)
+	}
+
+	result, err := lc.FilterResult("(objectClass=person)", []string{"dn"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	found = nil
+	for _, entry := range result.Entries {
+		found = append(found, entry.DN)
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, got %v", expected, found)
+	}
+}
+
+func TestSelectUser(t *testing.T) {
+	entries := []*ldap.Entry{
+		{DN: "uid=alice,ou=people,dc=example,dc=com"},
+		{DN: "uid=alice,ou=staff,ou=people,dc=example,dc=com"},
+	}
+	tests := []struct {
+		policy   string
+		entries  []*ldap.Entry
+		expected *ldap.Entry
+		err      error
+	}{
+		{"", entries, nil, ErrTooManyEntries},
+		{AmbiguousMatchError, entries, nil, ErrTooManyEntries},
+		{AmbiguousMatchFirst, entries, entries[0], nil},
+		{AmbiguousMatchMostSpecific, entries, entries[1], nil},
+		{AmbiguousMatchMostSpecific, append(entries, &ldap.Entry{DN: "uid=alice,ou=dev,ou=people,dc=example,dc=com"}), nil, ErrTooManyEntries},
+		{"", entries[:1], entries[0], nil},
+	}
+	for _, test := range tests {
+		lc := &LDAPClient{AmbiguousMatch: test.policy}
+		entry, err := lc.selectUser(test.entries)
+		if entry != test.expected || err != test.err {
+			t.Errorf("expected %+v and %v with %q, got %+v and %v", test.expected, test.err, test.policy, entry, err)
+		}
+	}
+}
+
+func TestPagingCookie(t *testing.T) {
+	paging := ldap.NewControlPaging(100)
+	paging.SetCookie([]byte("next"))
+	controls := []ldap.Control{ldap.NewControlManageDsaIT(false), paging}
+	if cookie := pagingCookie(controls); string(cookie) != "next" {
+		t.Errorf("expected the cookie, got %q", cookie)
+	}
+
+	// Without paging control, e.g. when the server does not support it
+	if cookie := pagingCookie(nil); cookie != nil {
+		t.Errorf("expected no cookie, got %q", cookie)
+	}
+}
+
+func TestEmptyPassword(t *testing.T) {
+	// Rejected before connecting to the unreachable host
+	lc := &LDAPClient{Host: "127.0.0.1", Port: deadPort(t), SkipTLS: true, UserFilter: "(uid=%s)", AllowUnauthenticatedBind: true}
+	if ok, _, err := lc.Authenticate("alice", ""); ok || err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v, %v", ok, err)
+	}
+	if ok, _, _, err := lc.AuthenticateWithGroups("alice", ""); ok || err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v, %v", ok, err)
+	}
+	if ok, err := lc.VerifyCredentials("alice", ""); ok || err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v, %v", ok, err)
+	}
+	if ok, err := lc.VerifyPassword("uid=alice,dc=example,dc=com", ""); ok || err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v, %v", ok, err)
+	}
+	if ok, err := lc.ComparePassword("uid=alice,dc=example,dc=com", ""); ok || err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials, got %v, %v", ok, err)
+	}
+	if lc.Conn != nil {
+		t.Errorf("expected no connection")
+	}
+}
+
+func TestVerifyCredentialsUnreachable(t *testing.T) {
+	lc := &LDAPClient{Host: "127.0.0.1", Port: deadPort(t), SkipTLS: true}
+	ok, err := lc.VerifyCredentials("alice", "secret")
+	if ok || err == nil {
+		t.Errorf("expected a connection error, got %v, %v", ok, err)
+	}
+	if lc.Conn != nil {
+		t.Errorf("expected the client to stay unconnected")
+	}
+}
+
+func TestComparePassword(t *testing.T) {
+	// alice has a cleartext userPassword, and bob an {SSHA} hash, which the
+	// server compares as is
+	var binds int
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		switch request.Tag {
+		case ldap.ApplicationCompareRequest:
+			dn := request.Children[0].Data.String()
+			password := request.Children[1].Children[1].Data.String()
+			if dn == "uid=alice,dc=example,dc=com" && password == "password" {
+				return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultCompareTrue)}
+			}
+			return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultCompareFalse)}
+		case ldap.ApplicationBindRequest:
+			binds++
+			if _, password := bindRequest(request); password != "password" {
+				return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultInvalidCredentials)}
+			}
+		}
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+	})
+
+	lc := &LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true}
+	defer lc.Close()
+
+	tests := []struct {
+		dn, password string
+		ok           bool
+		binds        int
+	}{
+		{"uid=alice,dc=example,dc=com", "password", true, 0},
+		{"uid=alice,dc=example,dc=com", "wrong", false, 1},
+		{"uid=bob,dc=example,dc=com", "password", true, 1},
+		{"uid=bob,dc=example,dc=com", "wrong", false, 1},
+	}
+	for _, test := range tests {
+		binds = 0
+		ok, err := lc.ComparePassword(test.dn, test.password)
+		if err != nil || ok != test.ok || binds != test.binds {
+			t.Errorf("expected %v after %d binds for %s, got %v after %d and %v", test.ok, test.binds, test.dn, ok, binds, err)
+		}
+	}
+}
+
+func TestAuthenticateSeparateConnection(t *testing.T) {
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			if dn, password := bindRequest(request); dn == "uid=alice,dc=example,dc=com" && password != "password" {
+				return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultInvalidCredentials)}
+			}
+		case ldap.ApplicationSearchRequest:
+			return []*ber.Packet{
+				ldapEntry(id, "uid=alice,dc=example,dc=com", nil),
+				ldapResult(id, request, ldap.LDAPResultSuccess),
+			}
+		}
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+	})
+
+	// A wrong password fails the same way with or without the option
+	for _, separate := range []bool{false, true} {
+		lc := &LDAPClient{
+			Host:                       "127.0.0.1",
+			Port:                       port,
+			SkipTLS:                    true,
+			Base:                       "dc=example,dc=com",
+			UserFilter:                 "(uid=%s)",
+			BindDN:                     "cn=reader,dc=example,dc=com",
+			BindPassword:               "secret",
+			VerifyOnSeparateConnection: separate,
+		}
+		ok, _, err := lc.Authenticate("alice", "wrong")
+		if ok || !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			t.Errorf("expected invalid credentials with VerifyOnSeparateConnection %v, got %v, %v", separate, ok, err)
+		}
+		if ok, _, err := lc.Authenticate("alice", "password"); !ok || err != nil {
+			t.Errorf("expected to authenticate with VerifyOnSeparateConnection %v, got %v, %v", separate, ok, err)
+		}
+		lc.Close()
+	}
+}
+
+func TestVerifyCredentialsActiveDirectory(t *testing.T) {
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		if dn, password := bindRequest(request); dn != `EXAMPLE\alice` || password != "password" {
+			return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultInvalidCredentials)}
+		}
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+	})
+
+	// Without a read only user, the logon name is bound as like Authenticate
+	lc := &LDAPClient{
+		Host:          "127.0.0.1",
+		Port:          port,
+		SkipTLS:       true,
+		Base:          "dc=example,dc=com",
+		DirectoryType: DirectoryActiveDirectory,
+		Domain:        "EXAMPLE",
+	}
+	if ok, err := lc.VerifyCredentials("alice", "password"); !ok || err != nil {
+		t.Errorf("expected valid credentials, got %v, %v", ok, err)
+	}
+	if ok, err := lc.VerifyCredentials("alice", "wrong"); ok || err != nil {
+		t.Errorf("expected invalid credentials, got %v, %v", ok, err)
+	}
+}
+
+func TestVerifyCredentialsBindOnce(t *testing.T) {
+	var readerBinds int
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			if dn, _ := bindRequest(request); dn == "cn=reader,dc=example,dc=com" {
+				readerBinds++
+			}
+		case ldap.ApplicationSearchRequest:
+			return []*ber.Packet{
+				ldapEntry(id, "uid=alice,dc=example,dc=com", nil),
+				ldapResult(id, request, ldap.LDAPResultSuccess),
+			}
+		}
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+	})
+
+	var ops []string
+	lc := &LDAPClient{
+		Host:                       "127.0.0.1",
+		Port:                       port,
+		SkipTLS:                    true,
+		Base:                       "dc=example,dc=com",
+		UserFilter:                 "(uid=%s)",
+		BindDN:                     "cn=reader,dc=example,dc=com",
+		BindPassword:               "secret",
+		BindStrategy:               BindOnce,
+		VerifyOnSeparateConnection: true,
+		Observe: func(op string, duration time.Duration, err error) {
+			ops = append(ops, op)
+		},
+	}
+	defer lc.Close()
+
+	if ok, _, err := lc.Authenticate("alice", "password"); !ok || err != nil {
+		t.Fatalf("expected to authenticate, got %v, %v", ok, err)
+	}
+	if !lc.boundAsBindUser {
+		t.Fatalf("expected the shared connection to be bound as the read only user")
+	}
+
+	// The separate connection is bound before looking the user up
+	ops, readerBinds = nil, 0
+	if ok, err := lc.VerifyCredentials("alice", "password"); !ok || err != nil {
+		t.Fatalf("expected valid credentials, got %v, %v", ok, err)
+	}
+	expected := []string{"connect", "bind", "search", "bind"}
+	if !reflect.DeepEqual(ops, expected) || readerBinds != 1 {
+		t.Errorf("expected %v with a bind as the read only user, got %v with %d", expected, ops, readerBinds)
+	}
+}
+
+func TestReadyUnreachable(t *testing.T) {
+	lc := &LDAPClient{Host: "127.0.0.1", Port: deadPort(t), SkipTLS: true}
+	if err := lc.Ready(context.Background()); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected ErrUnreachable, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := lc.Ready(ctx); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected ErrUnreachable, got %v", err)
+	}
+}
+
+// blockingDialer never connects, until the context is done.
+type blockingDialer struct {
+	done chan struct{}
+}
+
+func (d *blockingDialer) Dial(network, address string) (net.Conn, error) {
+	return nil, errors.New("Dial called instead of DialContext")
+}
+
+func (d *blockingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	<-ctx.Done()
+	close(d.done)
+	return nil, ctx.Err()
+}
+
+func TestReadyCancel(t *testing.T) {
+	// The dial gives up when the context is done
+	dialer := &blockingDialer{done: make(chan struct{})}
+	lc := &LDAPClient{Host: "127.0.0.1", Port: 1389, SkipTLS: true, Dialer: dialer}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := lc.Ready(ctx); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected ErrUnreachable, got %v", err)
+	}
+	select {
+	case <-dialer.done:
+	case <-time.After(time.Second):
+		t.Errorf("expected the dial to give up")
+	}
+
+	// The connection is closed when the context is done during the bind
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		return nil
+	})
+	open := OpenConnections()
+	lc = &LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true, BindDN: "cn=reader,dc=example,dc=com", BindPassword: "secret"}
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if err := lc.Ready(ctx); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("expected ErrUnreachable, got %v", err)
+	}
+	for deadline := time.Now().Add(time.Second); OpenConnections() != open; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the connection to be closed")
+		}
+	}
+}
+
+func TestGetGroupsOfUserRecursive(t *testing.T) {
+	admins := "cn=admins,ou=it,dc=example,dc=com"
+	otherAdmins := "cn=admins,ou=sales,dc=example,dc=com"
+	staff := "cn=staff,dc=example,dc=com"
+	all := "cn=all,dc=example,dc=com"
+	parentsOf := func(dn string) string {
+		return fmt.Sprintf("(|(member=%s)(uniqueMember=%s))", dn, dn)
+	}
+	// admins is in staff, which is in admins again, and in the admins of
+	// another OU, which are in all
+	groups := map[string][]string{
+		"(memberUid=alice)":    {admins},
+		parentsOf(admins):      {staff, otherAdmins},
+		parentsOf(staff):       {admins},
+		parentsOf(otherAdmins): {all},
+	}
+
+	searches := 0
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		if request.Tag != ldap.ApplicationSearchRequest {
+			return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+		}
+		searches++
+		filter, err := ldap.DecompileFilter(request.Children[6])
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		var response []*ber.Packet
+		for _, dn := range groups[filter] {
+			cn := strings.TrimPrefix(strings.Split(dn, ",")[0], "cn=")
+			response = append(response, ldapEntry(id, dn, map[string][]string{"cn": {cn}}))
+		}
+		return append(response, ldapResult(id, request, ldap.LDAPResultSuccess))
+	})
+
+	lc := &LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true, Base: "dc=example,dc=com", GroupFilter: "(memberUid=%s)"}
+	defer lc.Close()
+
+	found, err := lc.GetGroupsOfUserRecursive("alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"admins", "staff", "admins", "all"}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected %v, got %v", expected, found)
+	}
+	// The user search, then one search per group
+	if searches != 5 {
+		t.Errorf("expected 5 searches, got %d", searches)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	readerBinds := 0
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			// The bind back to the read only user after the user fails once
+			if dn, _ := bindRequest(request); dn == "cn=reader,dc=example,dc=com" {
+				readerBinds++
+				if readerBinds == 2 {
+					return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultUnavailable)}
+				}
+			}
+		case ldap.ApplicationSearchRequest:
+			return []*ber.Packet{
+				ldapEntry(id, "uid=alice,dc=example,dc=com", nil),
+				ldapResult(id, request, ldap.LDAPResultSuccess),
+			}
+		}
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+	})
+
+	lc := &LDAPClient{
+		Host:         "127.0.0.1",
+		Port:         port,
+		SkipTLS:      true,
+		Base:         "dc=example,dc=com",
+		UserFilter:   "(uid=%s)",
+		BindDN:       "cn=reader,dc=example,dc=com",
+		BindPassword: "secret",
+	}
+	defer lc.Close()
+
+	ok, _, err := lc.Authenticate("alice", "password")
+	if !ok || !ldap.IsErrorWithCode(err, ldap.LDAPResultUnavailable) {
+		t.Fatalf("expected the rebind to fail, got %v, %v", ok, err)
+	}
+	if lc.boundDN != "" {
+		t.Fatalf("expected the connection not to be bound, got %q", lc.boundDN)
+	}
+
+	if err := lc.Rebind(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lc.boundDN != lc.BindDN || readerBinds != 3 {
+		t.Errorf("expected the read only user to be bound again, got %q after %d binds", lc.boundDN, readerBinds)
+	}
+}
+
+func TestFollowReferralsControls(t *testing.T) {
+	var referredControls []ldap.Control
+	referred := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		if request.Tag == ldap.ApplicationSearchRequest {
+			referredControls = controls
+			return []*ber.Packet{
+				ldapEntry(id, "uid=alice,ou=eu,dc=example,dc=com", nil),
+				ldapResult(id, request, ldap.LDAPResultSuccess),
+			}
+		}
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+	})
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		if request.Tag == ldap.ApplicationSearchRequest {
+			return []*ber.Packet{
+				ldapReference(id, fmt.Sprintf("ldap://127.0.0.1:%d/ou=eu,dc=example,dc=com", referred)),
+				ldapResult(id, request, ldap.LDAPResultSuccess),
+			}
+		}
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+	})
+
+	lc := &LDAPClient{
+		Host:            "127.0.0.1",
+		Port:            port,
+		SkipTLS:         true,
+		Base:            "dc=example,dc=com",
+		BindDN:          "cn=service,dc=example,dc=com",
+		BindPassword:    "secret",
+		FollowReferrals: true,
+		ManageDsaIT:     true,
+		ProxiedAuthzID:  "dn:uid=bob,dc=example,dc=com",
+	}
+	defer lc.Close()
+
+	entries, err := lc.FilterEntries("(uid=alice)", []string{"dn"})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected the referred entry, got %v, %v", entries, err)
+	}
+	if control := ldap.FindControl(referredControls, controlTypeProxiedAuthz); control == nil || !strings.Contains(control.String(), "dn:uid=bob,dc=example,dc=com") {
+		t.Errorf("expected the proxied authorization control on the referred search, got %v", referredControls)
+	}
+	if ldap.FindControl(referredControls, ldap.ControlTypeManageDsaIT) == nil {
+		t.Errorf("expected the ManageDsaIT control on the referred search, got %v", referredControls)
+	}
+}
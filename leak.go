@@ -0,0 +1,60 @@
+package ldap
+
+import (
+	"log"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// openConnections counts the connections opened and not closed yet.
+var openConnections int64
+
+// OpenConnections returns the number of connections opened by the clients,
+// pooled ones included, and not closed yet, e.g. for tests to check that
+// none leaks.
+func OpenConnections() int {
+	return int(atomic.LoadInt64(&openConnections))
+}
+
+// closeConn closes a connection opened by dial.
+func closeConn(conn *ldap.Conn) {
+	conn.Close()
+	atomic.AddInt64(&openConnections, -1)
+}
+
+// leakDetector is only referenced by its client, so that it is garbage
+// collected along with it, and closes the connection left open, if any.
+type leakDetector struct {
+	conn    *ldap.Conn
+	address string
+	logger  Logger
+}
+
+// detectLeak watches the connection just opened to address, with
+// DetectLeaks.
+func (lc *LDAPClient) detectLeak(address string) {
+	if !lc.DetectLeaks {
+		return
+	}
+
+	// The finalizer is set on the detector rather than the client, which may
+	// be embedded in another struct, and must not reference the client
+	lc.leak = &leakDetector{conn: lc.Conn, address: address, logger: lc.Logger}
+	runtime.SetFinalizer(lc.leak, (*leakDetector).finalize)
+}
+
+// finalize closes the connection of a client that was not closed.
+func (d *leakDetector) finalize() {
+	if d.conn == nil {
+		return
+	}
+
+	closeConn(d.conn)
+	if d.logger != nil {
+		d.logger.Printf("ldap: client garbage collected without Close, closing its connection to %s", d.address)
+	} else {
+		log.Printf("ldap: client garbage collected without Close, closing its connection to %s", d.address)
+	}
+}
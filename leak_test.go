@@ -0,0 +1,62 @@
+package ldap
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type chanLogger chan string
+
+func (l chanLogger) Printf(format string, v ...interface{}) {
+	l <- fmt.Sprintf(format, v...)
+}
+
+func TestOpenConnections(t *testing.T) {
+	listener, port := listen(t)
+	defer listener.Close()
+
+	open := OpenConnections()
+	lc := &LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true}
+	if err := lc.Connect(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if OpenConnections() != open+1 {
+		t.Errorf("expected %d open connections, got %d", open+1, OpenConnections())
+	}
+
+	lc.Close()
+	lc.Close()
+	if OpenConnections() != open {
+		t.Errorf("expected %d open connections, got %d", open, OpenConnections())
+	}
+}
+
+func TestDetectLeaks(t *testing.T) {
+	listener, port := listen(t)
+	defer listener.Close()
+
+	open := OpenConnections()
+	logger := make(chanLogger, 1)
+	func() {
+		lc := &LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true, DetectLeaks: true, Logger: logger}
+		if err := lc.Connect(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}()
+
+	// Finalizers run in the background after a collection
+	for i := 0; i < 50; i++ {
+		runtime.GC()
+		select {
+		case <-logger:
+			if OpenConnections() != open {
+				t.Errorf("expected the leaked connection to be closed")
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Errorf("expected the leak to be logged")
+}
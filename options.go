@@ -0,0 +1,154 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"time"
+)
+
+// Option configures the client returned by New.
+type Option func(*LDAPClient)
+
+// New returns a client configured by the given options, after checking that
+// they are consistent. The port defaults to 636 with SSL and to 389
+// otherwise. The returned client is not connected yet.
+func New(opts ...Option) (*LDAPClient, error) {
+	lc := &LDAPClient{}
+	for _, opt := range opts {
+		opt(lc)
+	}
+
+	err := lc.applyURL()
+	if err != nil {
+		return nil, err
+	}
+	if lc.Host == "" && len(lc.Hosts) == 0 {
+		return nil, errors.New("No host configured")
+	}
+	lc.Port = lc.port()
+
+	err = lc.validate()
+	if err != nil {
+		return nil, err
+	}
+	// Create the cache before the client is copied, to share it
+	lc.searchCache()
+	return lc, nil
+}
+
+// WithURL connects to an ldap:// or ldaps:// URL, see LDAPClient.URL.
+func WithURL(url string) Option {
+	return func(lc *LDAPClient) {
+		lc.URL = url
+	}
+}
+
+// WithHost connects to host, on port unless zero.
+func WithHost(host string, port int) Option {
+	return func(lc *LDAPClient) {
+		lc.Host = host
+		lc.Port = port
+	}
+}
+
+// WithHosts fails over between replicas, see LDAPClient.Hosts.
+func WithHosts(hosts ...string) Option {
+	return func(lc *LDAPClient) {
+		lc.Hosts = hosts
+	}
+}
+
+// WithSSL connects with LDAPS instead of StartTLS.
+func WithSSL() Option {
+	return func(lc *LDAPClient) {
+		lc.UseSSL = true
+	}
+}
+
+// WithSkipTLS connects without TLS, not upgrading with StartTLS.
+func WithSkipTLS() Option {
+	return func(lc *LDAPClient) {
+		lc.SkipTLS = true
+	}
+}
+
+// WithTLSConfig uses config for LDAPS and StartTLS connections.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(lc *LDAPClient) {
+		lc.TLSConfig = config
+	}
+}
+
+// WithStartTLSConfig uses config for StartTLS instead of the TLSConfig.
+func WithStartTLSConfig(config *tls.Config) Option {
+	return func(lc *LDAPClient) {
+		lc.StartTLSConfig = config
+	}
+}
+
+// WithServerName verifies the server certificate against name instead of
+// the host, e.g. when connecting to an IP address.
+func WithServerName(name string) Option {
+	return func(lc *LDAPClient) {
+		lc.ServerName = name
+	}
+}
+
+// WithInsecureSkipVerify does not verify the server certificate, e.g. for
+// tests against a self-signed one.
+func WithInsecureSkipVerify() Option {
+	return func(lc *LDAPClient) {
+		lc.InsecureSkipVerify = true
+	}
+}
+
+// WithBase searches under base.
+func WithBase(base string) Option {
+	return func(lc *LDAPClient) {
+		lc.Base = base
+	}
+}
+
+// WithBindCredentials binds as the read only user dn.
+func WithBindCredentials(dn, password string) Option {
+	return func(lc *LDAPClient) {
+		lc.BindDN = dn
+		lc.BindPassword = password
+	}
+}
+
+// WithUserFilter finds users with filter, e.g. "(uid=%s)".
+func WithUserFilter(filter string) Option {
+	return func(lc *LDAPClient) {
+		lc.UserFilter = filter
+	}
+}
+
+// WithGroupFilter finds the groups of a user with filter, e.g.
+// "(memberUid=%s)".
+func WithGroupFilter(filter string) Option {
+	return func(lc *LDAPClient) {
+		lc.GroupFilter = filter
+	}
+}
+
+// WithAttributes returns attributes from Authenticate.
+func WithAttributes(attributes ...string) Option {
+	return func(lc *LDAPClient) {
+		lc.Attributes = attributes
+	}
+}
+
+// WithTimeout bounds every operation, see LDAPClient.OperationTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(lc *LDAPClient) {
+		lc.OperationTimeout = timeout
+	}
+}
+
+// WithLogger traces every operation to logger.
+func WithLogger(logger Logger) Option {
+	return func(lc *LDAPClient) {
+		lc.Logger = logger
+	}
+}
@@ -0,0 +1,66 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	lc, err := New(
+		WithHost("ldap.example.com", 0),
+		WithBase("dc=example,dc=com"),
+		WithBindCredentials("cn=readonly,dc=example,dc=com", "secret"),
+		WithUserFilter("(uid=%s)"),
+		WithTimeout(5*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lc.Host != "ldap.example.com" || lc.Port != 389 || lc.BindDN != "cn=readonly,dc=example,dc=com" || lc.OperationTimeout != 5*time.Second {
+		t.Errorf("unexpected client %+v", lc)
+	}
+
+	lc, err = New(WithHost("ldap.example.com", 0), WithSSL())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lc.Port != 636 {
+		t.Errorf("expected port 636 with SSL, got %d", lc.Port)
+	}
+
+	lc, err = New(WithURL("ldaps://ldap.example.com:3269"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lc.Host != "ldap.example.com" || lc.Port != 3269 || !lc.UseSSL {
+		t.Errorf("unexpected client %+v", lc)
+	}
+
+	startTLSConfig := &tls.Config{}
+	lc, err = New(WithHost("10.0.0.1", 0), WithServerName("ldap.example.com"), WithInsecureSkipVerify(), WithStartTLSConfig(startTLSConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lc.ServerName != "ldap.example.com" || !lc.InsecureSkipVerify || lc.StartTLSConfig != startTLSConfig {
+		t.Errorf("unexpected client %+v", lc)
+	}
+
+	lc, err = New(WithHost("ldap.example.com", 0), WithSkipTLS())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !lc.SkipTLS || lc.Port != 389 {
+		t.Errorf("unexpected client %+v", lc)
+	}
+	if _, err := New(WithHost("ldap.example.com", 0), WithSSL(), WithSkipTLS()); err == nil {
+		t.Errorf("expected an error with SSL and SkipTLS")
+	}
+
+	if _, err := New(WithBase("dc=example,dc=com")); err == nil {
+		t.Errorf("expected an error without host")
+	}
+	if _, err := New(WithHost("ldap.example.com", 70000)); err == nil {
+		t.Errorf("expected an error with an invalid port")
+	}
+}
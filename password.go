@@ -0,0 +1,42 @@
+package ldap
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+)
+
+// sshaSaltSize is the number of random salt bytes used by HashSSHA.
+const sshaSaltSize = 8
+
+// HashSSHA returns the RFC 2307 {SSHA} hash of password with a random salt,
+// i.e. "{SSHA}" + base64(sha1(password + salt) + salt).
+func HashSSHA(password string) string {
+	salt := make([]byte, sshaSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return hashSSHA(password, salt)
+}
+
+func hashSSHA(password string, salt []byte) string {
+	hash := sha1.New()
+	hash.Write([]byte(password))
+	hash.Write(salt)
+	b := append(hash.Sum(nil), salt...)
+	return "{SSHA}" + base64.StdEncoding.EncodeToString(b)
+}
+
+// HashSHA returns the RFC 2307 unsalted {SHA} hash of password.
+func HashSHA(password string) string {
+	b := sha1.Sum([]byte(password))
+	return "{SHA}" + base64.StdEncoding.EncodeToString(b[:])
+}
+
+// HashMD5 returns the RFC 2307 unsalted {MD5} hash of password.
+// It is only kept for legacy directories, prefer HashSSHA.
+func HashMD5(password string) string {
+	b := md5.Sum([]byte(password))
+	return "{MD5}" + base64.StdEncoding.EncodeToString(b[:])
+}
@@ -0,0 +1,56 @@
+package ldap
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestHashSSHA(t *testing.T) {
+	hashed := HashSSHA("supersecret")
+	if !strings.HasPrefix(hashed, "{SSHA}") {
+		t.Fatalf("expected {SSHA} prefix, got %s", hashed)
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hashed, "{SSHA}"))
+	if err != nil {
+		t.Fatalf("invalid base64: %v", err)
+	}
+	if len(b) != sha1.Size+sshaSaltSize {
+		t.Fatalf("expected %d bytes, got %d", sha1.Size+sshaSaltSize, len(b))
+	}
+
+	// The salt is appended to both the password and the digest.
+	digest, salt := b[:sha1.Size], b[sha1.Size:]
+	expected := sha1.Sum(append([]byte("supersecret"), salt...))
+	if !bytes.Equal(digest, expected[:]) {
+		t.Errorf("digest does not match sha1(password + salt)")
+	}
+
+	if HashSSHA("supersecret") == hashed {
+		t.Errorf("expected a random salt for each hash")
+	}
+}
+
+func TestHashSSHAKnownSalt(t *testing.T) {
+	expected := "{SSHA}+RFhsab2AfzZ0VfEdyknXtUT06RhYmNk"
+	if hashed := hashSSHA("secret", []byte("abcd")); hashed != expected {
+		t.Errorf("expected %s, got %s", expected, hashed)
+	}
+}
+
+func TestHashSHA(t *testing.T) {
+	expected := "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+	if hashed := HashSHA("secret"); hashed != expected {
+		t.Errorf("expected %s, got %s", expected, hashed)
+	}
+}
+
+func TestHashMD5(t *testing.T) {
+	expected := "{MD5}Xr4ilOzQ4PCOq3aQ0qbuaQ=="
+	if hashed := HashMD5("secret"); hashed != expected {
+		t.Errorf("expected %s, got %s", expected, hashed)
+	}
+}
@@ -0,0 +1,212 @@
+package ldap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Pool keeps connections to the ldap backend bound with the read only user,
+// so that they can be reused by concurrent requests.
+type Pool struct {
+	// Client configures the connections of the pool, it is never connected.
+	Client *LDAPClient
+	// MaxIdle is the maximum number of idle connections kept.
+	MaxIdle int
+	// MaxIdleTime closes the connections idle for longer, as directories
+	// often drop them, 0 keeps them forever.
+	MaxIdleTime time.Duration
+	// HealthCheckInterval is how often idle connections are checked in the
+	// background, defaulting to half of MaxIdleTime. It must be set before
+	// the first Get or Put, which start the health check.
+	HealthCheckInterval time.Duration
+
+	mu      sync.Mutex
+	idle    []pooledClient
+	closed  bool
+	started bool
+	stop    chan struct{}
+}
+
+type pooledClient struct {
+	client *LDAPClient
+	since  time.Time
+}
+
+// NewPool returns a pool of connections configured by client. The background
+// health check is started by the first Get or Put when maxIdleTime is set.
+func NewPool(client *LDAPClient, maxIdle int, maxIdleTime time.Duration) *Pool {
+	p := &Pool{
+		Client:      client,
+		MaxIdle:     maxIdle,
+		MaxIdleTime: maxIdleTime,
+	}
+	// Create the cache before the client is copied, to share it
+	client.searchCache()
+	return p
+}
+
+// startHealthCheck starts the background health check once, when
+// MaxIdleTime is set. It must be called with p.mu held.
+func (p *Pool) startHealthCheck() {
+	if p.started || p.closed || p.MaxIdleTime <= 0 {
+		return
+	}
+	p.started = true
+
+	interval := p.HealthCheckInterval
+	if interval <= 0 {
+		interval = p.MaxIdleTime / 2
+	}
+	p.stop = make(chan struct{})
+	go p.healthCheck(interval, p.stop)
+}
+
+// Get returns a live idle connection, or a new one when none is available.
+// The caller must give it back with Put.
+func (p *Pool) Get() (*LDAPClient, error) {
+	for {
+		p.mu.Lock()
+		p.startHealthCheck()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		pooled := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if p.expired(pooled) || pooled.client.Conn == nil || pooled.client.Conn.IsClosing() {
+			pooled.client.Close()
+			continue
+		}
+		return pooled.client, nil
+	}
+
+	client := *p.Client
+	client.Conn = nil
+	err := client.connectAdmin()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &client, nil
+}
+
+// Put gives a connection back to the pool, closing it when the pool is full
+// or closed.
+func (p *Pool) Put(client *LDAPClient) {
+	p.mu.Lock()
+	p.startHealthCheck()
+	if p.closed || client.Conn == nil || len(p.idle) >= p.MaxIdle {
+		p.mu.Unlock()
+		client.Close()
+		return
+	}
+	p.idle = append(p.idle, pooledClient{client: client, since: time.Now()})
+	p.mu.Unlock()
+}
+
+// Close closes the idle connections and stops the health check. Connections
+// given back afterwards are closed.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	stop := p.stop
+	p.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+	}
+	for _, pooled := range idle {
+		pooled.client.Close()
+	}
+}
+
+// healthCheck closes the idle connections that expired or no longer answer
+// every interval, until stop is closed.
+func (p *Pool) healthCheck(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.checkIdle()
+		}
+	}
+}
+
+// checkIdle closes the idle connections that expired or fail a root DSE read.
+func (p *Pool) checkIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	var live []pooledClient
+	for _, pooled := range idle {
+		if p.expired(pooled) || !pooled.client.ping() {
+			pooled.client.Close()
+			continue
+		}
+		live = append(live, pooled)
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		for _, pooled := range live {
+			pooled.client.Close()
+		}
+		return
+	}
+	// Connections given back during the check are more recent, and the
+	// oldest ones are closed when they exceed MaxIdle
+	p.idle = append(live, p.idle...)
+	var surplus []pooledClient
+	keep := p.MaxIdle
+	if keep < 0 {
+		keep = 0
+	}
+	if len(p.idle) > keep {
+		surplus = p.idle[:len(p.idle)-keep]
+		p.idle = append([]pooledClient{}, p.idle[len(p.idle)-keep:]...)
+	}
+	p.mu.Unlock()
+
+	for _, pooled := range surplus {
+		pooled.client.Close()
+	}
+}
+
+// expired reports whether a connection has been idle for too long.
+func (p *Pool) expired(pooled pooledClient) bool {
+	return p.MaxIdleTime > 0 && time.Since(pooled.since) > p.MaxIdleTime
+}
+
+// ping reports whether the connection still answers a root DSE read.
+func (lc *LDAPClient) ping() bool {
+	if lc.Conn == nil || lc.Conn.IsClosing() {
+		return false
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		"",
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		[]string{NoAttributes},
+		nil,
+	)
+	_, err := lc.search(searchRequest)
+	return err == nil
+}
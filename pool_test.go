@@ -0,0 +1,176 @@
+package ldap
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func listen(t *testing.T) (net.Listener, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the connections open until the test ends
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	return listener, listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestPoolReuse(t *testing.T) {
+	listener, port := listen(t)
+	defer listener.Close()
+
+	p := NewPool(&LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true}, 1, 0)
+	defer p.Close()
+
+	client, err := p.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client == p.Client || client.Conn == nil {
+		t.Fatalf("expected a new connected client")
+	}
+	p.Put(client)
+
+	reused, err := p.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reused != client {
+		t.Errorf("expected the idle client to be reused")
+	}
+
+	// The pool only keeps MaxIdle clients
+	other, err := p.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p.Put(reused)
+	p.Put(other)
+	if other.Conn != nil {
+		t.Errorf("expected the client over MaxIdle to be closed")
+	}
+}
+
+func TestPoolMaxIdleTime(t *testing.T) {
+	listener, port := listen(t)
+	defer listener.Close()
+
+	p := NewPool(&LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true}, 1, 10*time.Millisecond)
+	p.HealthCheckInterval = time.Hour
+	defer p.Close()
+
+	client, err := p.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p.Put(client)
+	time.Sleep(20 * time.Millisecond)
+
+	fresh, err := p.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fresh == client || client.Conn != nil {
+		t.Errorf("expected the expired client to be closed and replaced")
+	}
+	p.Put(fresh)
+	time.Sleep(20 * time.Millisecond)
+
+	p.checkIdle()
+	if len(p.idle) != 0 || fresh.Conn != nil {
+		t.Errorf("expected the health check to close the expired client")
+	}
+}
+
+func TestBindOnce(t *testing.T) {
+	listener, port := listen(t)
+	defer listener.Close()
+
+	binds := 0
+	lc := &LDAPClient{
+		Host:         "127.0.0.1",
+		Port:         port,
+		SkipTLS:      true,
+		BindDN:       "cn=reader,dc=example,dc=com",
+		BindPassword: "secret",
+		BindStrategy: BindOnce,
+		Observe: func(op string, duration time.Duration, err error) {
+			if op == "bind" {
+				binds++
+			}
+		},
+	}
+	if err := lc.Connect(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer lc.Close()
+
+	// Known to be bound with the read only user
+	lc.boundAsBindUser = true
+	if err := lc.Rebind(); err != nil || binds != 0 {
+		t.Errorf("expected no bind, got %d and %v", binds, err)
+	}
+
+	lc.Close()
+	if lc.boundAsBindUser {
+		t.Errorf("expected a closed connection not to be bound")
+	}
+}
+
+func TestPoolCheckIdleMaxIdle(t *testing.T) {
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+	})
+
+	p := NewPool(&LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true}, 1, time.Hour)
+	defer p.Close()
+
+	var clients []*LDAPClient
+	for i := 0; i < 2; i++ {
+		client, err := p.Get()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		clients = append(clients, client)
+	}
+	// Clients given back during a check can exceed MaxIdle
+	p.mu.Lock()
+	for _, client := range clients {
+		p.idle = append(p.idle, pooledClient{client: client, since: time.Now()})
+	}
+	p.mu.Unlock()
+
+	p.checkIdle()
+	if len(p.idle) != 1 || p.idle[0].client != clients[1] {
+		t.Fatalf("expected only the most recent client to be kept, got %d", len(p.idle))
+	}
+	if clients[0].Conn != nil {
+		t.Errorf("expected the client over MaxIdle to be closed")
+	}
+}
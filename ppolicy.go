@@ -0,0 +1,182 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// passwordModifyOID is the RFC 3062 password modify extended operation OID.
+const passwordModifyOID = "1.3.6.1.4.1.4203.1.11.1"
+
+// PasswordPolicyError is returned when the server rejects a new password
+// because of its password policy, e.g. as too short, already in the history
+// or not complex enough.
+type PasswordPolicyError struct {
+	// Code is the error of the password policy response control, e.g.
+	// ldap.BeheraPasswordTooShort or ldap.BeheraPasswordInHistory, or -1
+	// when the server did not return the control.
+	Code int8
+	// Reason describes Code, e.g. "Password is too short for policy", or
+	// else is the explanation given by the server, e.g. "Password is in
+	// history of old passwords" with the OpenLDAP ppolicy overlay.
+	Reason string
+	// Err is the error returned by the server.
+	Err error
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "Password rejected by policy: " + e.Reason
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return e.Err
+}
+
+// passwordPolicyError turns the error returned when a password change breaks
+// the policy into a PasswordPolicyError, with the error of the password
+// policy response control, or else the diagnostic message of a constraint
+// violation, as reason.
+func passwordPolicyError(err error) error {
+	var ldapErr *ldap.Error
+	if !errors.As(err, &ldapErr) {
+		return err
+	}
+
+	if policy := passwordPolicyResponse(ldapErr.Packet); policy != nil && policy.Error >= 0 {
+		reason := policy.ErrorString
+		if reason == "" {
+			reason = fmt.Sprintf("password policy error %d", policy.Error)
+		}
+		return &PasswordPolicyError{Code: policy.Error, Reason: reason, Err: err}
+	}
+
+	if ldapErr.ResultCode != ldap.LDAPResultConstraintViolation {
+		return err
+	}
+	reason := "constraint violation"
+	if ldapErr.Err != nil && ldapErr.Err.Error() != "" {
+		reason = ldapErr.Err.Error()
+	}
+	return &PasswordPolicyError{Code: -1, Reason: reason, Err: err}
+}
+
+// passwordPolicyResponse returns the password policy response control of a
+// response packet, if any.
+func passwordPolicyResponse(packet *ber.Packet) *ldap.ControlBeheraPasswordPolicy {
+	if packet == nil || len(packet.Children) < 3 {
+		return nil
+	}
+	for _, child := range packet.Children[2].Children {
+		control, err := ldap.DecodeControl(child)
+		if policy, ok := control.(*ldap.ControlBeheraPasswordPolicy); err == nil && ok {
+			return policy
+		}
+	}
+	return nil
+}
+
+// passwordModifyValue encodes the value of a password modify extended
+// request, to send it along with controls.
+func passwordModifyValue(passwordModifyRequest *ldap.PasswordModifyRequest) *ber.Packet {
+	sequence := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Password Modify Request")
+	if passwordModifyRequest.UserIdentity != "" {
+		sequence.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, passwordModifyRequest.UserIdentity, "User Identity"))
+	}
+	if passwordModifyRequest.OldPassword != "" {
+		sequence.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 1, passwordModifyRequest.OldPassword, "Old Password"))
+	}
+	if passwordModifyRequest.NewPassword != "" {
+		sequence.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 2, passwordModifyRequest.NewPassword, "New Password"))
+	}
+
+	value := ber.Encode(ber.ClassContext, ber.TypePrimitive, 1, nil, "Request Value")
+	value.AppendChild(sequence)
+	return value
+}
+
+// GetPasswordExpiration returns the time the password of a user expires, or
+// a zero time when it never does: the Active Directory
+// msDS-UserPasswordExpiryTimeComputed, or the pwdChangedTime of the user plus
+// the pwdMaxAge of their OpenLDAP password policy, the one named by their
+// pwdPolicySubentry or else PasswordPolicyDN.
+func (lc *LDAPClient) GetPasswordExpiration(userDN string) (time.Time, error) {
+	err := lc.connectAdmin()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	ad, err := lc.activeDirectory()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if ad {
+		entry, err := lc.getEntry(userDN, []string{"msDS-UserPasswordExpiryTimeComputed"})
+		if err != nil {
+			return time.Time{}, err
+		}
+		return parseFileTime(entry.GetAttributeValue("msDS-UserPasswordExpiryTimeComputed"))
+	}
+
+	entry, err := lc.getEntry(userDN, []string{"pwdChangedTime", "pwdPolicySubentry"})
+	if err != nil {
+		return time.Time{}, err
+	}
+	policyDN := entry.GetAttributeValue("pwdPolicySubentry")
+	if policyDN == "" {
+		policyDN = lc.PasswordPolicyDN
+	}
+	if policyDN == "" {
+		return time.Time{}, nil
+	}
+
+	policy, err := lc.getEntry(policyDN, []string{"pwdMaxAge"})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return passwordExpiration(entry.GetAttributeValue("pwdChangedTime"), policy.GetAttributeValue("pwdMaxAge"))
+}
+
+// PasswordExpiresIn returns the time left before the password of a user
+// expires, negative once expired, and false when it never expires.
+func (lc *LDAPClient) PasswordExpiresIn(userDN string) (time.Duration, bool, error) {
+	expiration, err := lc.GetPasswordExpiration(userDN)
+	if err != nil || expiration.IsZero() {
+		return 0, false, err
+	}
+	return time.Until(expiration), true, nil
+}
+
+// passwordExpiration returns the time a password changed at the generalized
+// time changed expires with a pwdMaxAge in seconds, or a zero time when
+// either is missing or the maximum age is 0, meaning never.
+func passwordExpiration(changed, maxAge string) (time.Time, error) {
+	if changed == "" || maxAge == "" || maxAge == "0" {
+		return time.Time{}, nil
+	}
+
+	seconds, err := strconv.ParseInt(maxAge, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid pwdMaxAge %q: %v", maxAge, err)
+	}
+	changedTime, err := parseGeneralizedTime(changed)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return changedTime.Add(time.Duration(seconds) * time.Second), nil
+}
+
+// parseGeneralizedTime parses a generalized time, e.g. "20240101120000Z", with
+// optional fractional seconds and offset.
+func parseGeneralizedTime(value string) (time.Time, error) {
+	t, err := time.Parse("20060102150405Z0700", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid generalized time %q: %v", value, err)
+	}
+	return t.UTC(), nil
+}
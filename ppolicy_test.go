@@ -0,0 +1,101 @@
+package ldap
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestPasswordPolicyError(t *testing.T) {
+	violation := &ldap.Error{ResultCode: ldap.LDAPResultConstraintViolation, Err: errors.New("Password is in history of old passwords")}
+	err := passwordPolicyError(violation)
+
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("expected a PasswordPolicyError, got %v", err)
+	}
+	if policyErr.Reason != "Password is in history of old passwords" || policyErr.Code != -1 {
+		t.Errorf("unexpected reason %q and code %d", policyErr.Reason, policyErr.Code)
+	}
+	if !ldap.IsErrorWithCode(errors.Unwrap(err), ldap.LDAPResultConstraintViolation) {
+		t.Errorf("expected the server error to be wrapped")
+	}
+
+	// Other errors are returned as is
+	other := &ldap.Error{ResultCode: ldap.LDAPResultInvalidCredentials}
+	if err := passwordPolicyError(other); err != other {
+		t.Errorf("expected %v, got %v", other, err)
+	}
+	if err := passwordPolicyError(nil); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestPasswordPolicyResponse(t *testing.T) {
+	// passwordPolicyResponseValue holds the error passwordInHistory
+	value := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Password Policy Response")
+	value.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimitive, 1, int64(ldap.BeheraPasswordInHistory), "Error"))
+	response := ldap.NewControlString(ldap.ControlTypeBeheraPasswordPolicy, false, string(value.Bytes()))
+
+	var requested bool
+	var identity string
+	port := serve(t, func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet {
+		if request.Tag != ldap.ApplicationExtendedRequest {
+			return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultSuccess)}
+		}
+		requested = ldap.FindControl(controls, ldap.ControlTypeBeheraPasswordPolicy) != nil
+		if value := ber.DecodePacket(request.Children[1].Data.Bytes()); len(value.Children) == 2 {
+			identity = value.Children[0].Data.String()
+		}
+		return []*ber.Packet{ldapResult(id, request, ldap.LDAPResultConstraintViolation, response)}
+	})
+
+	lc := &LDAPClient{Host: "127.0.0.1", Port: port, SkipTLS: true, DirectoryType: DirectoryOpenLDAP}
+	defer lc.Close()
+
+	err := lc.SetPassword("uid=alice,dc=example,dc=com", "secret")
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("expected a PasswordPolicyError, got %v", err)
+	}
+	if policyErr.Code != ldap.BeheraPasswordInHistory || policyErr.Reason != ldap.BeheraPasswordPolicyErrorMap[ldap.BeheraPasswordInHistory] {
+		t.Errorf("unexpected code %d and reason %q", policyErr.Code, policyErr.Reason)
+	}
+	if !ldap.IsErrorWithCode(errors.Unwrap(err), ldap.LDAPResultConstraintViolation) {
+		t.Errorf("expected the server error to be wrapped")
+	}
+	if !requested || identity != "uid=alice,dc=example,dc=com" {
+		t.Errorf("expected a password modify of alice requesting the password policy control, got %q and %v", identity, requested)
+	}
+}
+
+func TestPasswordExpiration(t *testing.T) {
+	tests := []struct {
+		changed, maxAge string
+		expected        time.Time
+	}{
+		{"20240101120000Z", "86400", time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)},
+		{"20240101120000.5Z", "60", time.Date(2024, 1, 1, 12, 1, 0, 5e8, time.UTC)},
+		{"20240101120000+0100", "3600", time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		// Never expires
+		{"20240101120000Z", "0", time.Time{}},
+		{"20240101120000Z", "", time.Time{}},
+		{"", "86400", time.Time{}},
+	}
+	for _, test := range tests {
+		expiration, err := passwordExpiration(test.changed, test.maxAge)
+		if err != nil {
+			t.Fatalf("unexpected error for %q and %q: %v", test.changed, test.maxAge, err)
+		}
+		if !expiration.Equal(test.expected) {
+			t.Errorf("expected %s for %q and %q, got %s", test.expected, test.changed, test.maxAge, expiration)
+		}
+	}
+
+	if _, err := passwordExpiration("yesterday", "86400"); err == nil {
+		t.Errorf("expected an error for an invalid time")
+	}
+}
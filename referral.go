@@ -0,0 +1,97 @@
+package ldap
+
+import (
+	"errors"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// defaultMaxReferralHops is used when MaxReferralHops is not set.
+const defaultMaxReferralHops = 5
+
+// ErrReferralLimit is returned when following more referrals than allowed by
+// MaxReferralHops, which usually means the referrals form a loop.
+var ErrReferralLimit = errors.New("Too many referral hops")
+
+// followReferrals runs the search request against every referral URL and
+// returns the referred entries.
+func (lc *LDAPClient) followReferrals(searchRequest *ldap.SearchRequest, referrals []string, options SearchOptions) ([]*ldap.Entry, error) {
+	maxHops := lc.MaxReferralHops
+	if maxHops == 0 {
+		maxHops = defaultMaxReferralHops
+	}
+	if len(referrals) > 0 && lc.referralHops >= maxHops {
+		return nil, ErrReferralLimit
+	}
+
+	var entries []*ldap.Entry
+	for _, referral := range referrals {
+		referred, base, err := lc.referralClient(referral)
+		if err != nil {
+			return nil, err
+		}
+		if base == "" {
+			base = searchRequest.BaseDN
+		}
+
+		found, err := referred.searchBase(base, searchRequest.Filter, searchRequest.Attributes, options)
+		referred.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	return entries, nil
+}
+
+// referralClient returns a connected client for a referral URL, reusing the
+// bind credentials, TLS settings and request controls, so that referred
+// operations run with the same identity, along with the base DN of the URL.
+func (lc *LDAPClient) referralClient(referral string) (*LDAPClient, string, error) {
+	host, port, useSSL, base, err := parseURL(referral)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// ServicePrincipal names the original server, the referred one gets the
+	// default for its host
+	referred := &LDAPClient{
+		Host:               host,
+		Port:               port,
+		ServerName:         host,
+		UseSSL:             useSSL,
+		SkipTLS:            lc.SkipTLS && !useSSL,
+		InsecureSkipVerify: lc.InsecureSkipVerify,
+		TLSConfig:          lc.TLSConfig,
+		StartTLSConfig:     lc.StartTLSConfig,
+		StartTLSInsecure:   lc.StartTLSInsecure,
+		MinTLSVersion:      lc.MinTLSVersion,
+		Dialer:             lc.Dialer,
+		BindDN:             lc.BindDN,
+		BindPassword:       lc.BindPassword,
+		AuthMethod:         lc.AuthMethod,
+		Domain:             lc.Domain,
+		Krb5ConfPath:       lc.Krb5ConfPath,
+		Krb5CCachePath:     lc.Krb5CCachePath,
+		Krb5KeytabPath:     lc.Krb5KeytabPath,
+		Krb5Principal:      lc.Krb5Principal,
+		Krb5Realm:          lc.Krb5Realm,
+		FollowReferrals:    lc.FollowReferrals,
+		MaxReferralHops:    lc.MaxReferralHops,
+		Logger:             lc.Logger,
+		Observe:            lc.Observe,
+		Controls:           lc.Controls,
+		ManageDsaIT:        lc.ManageDsaIT,
+		ProxiedAuthzID:     lc.ProxiedAuthzID,
+		RetryPolicy:        lc.RetryPolicy,
+		OperationTimeout:   lc.OperationTimeout,
+		referralHops:       lc.referralHops + 1,
+	}
+
+	err = referred.connectAdmin()
+	if err != nil {
+		referred.Close()
+		return nil, "", err
+	}
+	return referred, base, nil
+}
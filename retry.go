@@ -0,0 +1,97 @@
+package ldap
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// RetryPolicy retries the operations failing with a transient error: network
+// errors, including timeouts and refused connections, for connections and
+// searches, and busy or unavailable servers for any operation. Writes are
+// not retried after a network error, as they may have been performed.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled for every
+	// following one.
+	BaseDelay time.Duration
+	// MaxDelay caps the doubled delay, before jitter, defaulting to a
+	// minute.
+	MaxDelay time.Duration
+	// Jitter is the maximum random delay added to every retry, so that
+	// clients do not retry in lockstep.
+	Jitter time.Duration
+}
+
+// defaultMaxDelay caps the delay between retries when MaxDelay is not set.
+const defaultMaxDelay = time.Minute
+
+// delay returns the delay before the given retry, starting at 1.
+func (rp *RetryPolicy) delay(retry int) time.Duration {
+	maxDelay := rp.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxDelay
+	}
+
+	// Double step by step, as shifting overflows with many attempts
+	delay := rp.BaseDelay
+	for i := 1; i < retry && delay < maxDelay; i++ {
+		if delay > maxDelay/2 {
+			delay = maxDelay
+			break
+		}
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if rp.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(rp.Jitter)))
+	}
+	return delay
+}
+
+// unsentError wraps the error of an operation that failed before its request
+// was sent, e.g. while dialing, so that it is retried even when not
+// idempotent.
+type unsentError struct {
+	err error
+}
+
+func (e *unsentError) Error() string { return e.err.Error() }
+
+func (e *unsentError) Unwrap() error { return e.err }
+
+// retry runs op until it succeeds, fails with an error that is not
+// transient, or the attempts of the RetryPolicy are exhausted. Network
+// errors are only transient for idempotent operations, or when op returns
+// them wrapped in an unsentError, which is unwrapped.
+func (lc *LDAPClient) retry(idempotent bool, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		var unsent *unsentError
+		if errors.As(err, &unsent) {
+			err = unsent.err
+		}
+		if err == nil || lc.RetryPolicy == nil || attempt >= lc.RetryPolicy.MaxAttempts || !isTransient(err, idempotent || unsent != nil) {
+			return err
+		}
+		time.Sleep(lc.RetryPolicy.delay(attempt))
+	}
+}
+
+// isTransient reports whether an operation failing with err may succeed when
+// retried. Authentication failures and missing entries never do.
+func isTransient(err error, idempotent bool) bool {
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultBusy), ldap.IsErrorWithCode(err, ldap.LDAPResultUnavailable):
+		return true
+	case ldap.IsErrorWithCode(err, ldap.ErrorNetwork):
+		return idempotent
+	default:
+		return false
+	}
+}
@@ -0,0 +1,101 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestRetry(t *testing.T) {
+	busy := &ldap.Error{ResultCode: ldap.LDAPResultBusy}
+	network := &ldap.Error{ResultCode: ldap.ErrorNetwork}
+	invalidCredentials := &ldap.Error{ResultCode: ldap.LDAPResultInvalidCredentials}
+
+	tests := []struct {
+		policy     *RetryPolicy
+		idempotent bool
+		err        error
+		attempts   int
+	}{
+		{nil, true, busy, 1},
+		{&RetryPolicy{MaxAttempts: 3}, true, busy, 3},
+		{&RetryPolicy{MaxAttempts: 3}, false, busy, 3},
+		{&RetryPolicy{MaxAttempts: 3}, true, network, 3},
+		{&RetryPolicy{MaxAttempts: 3}, false, network, 1},
+		{&RetryPolicy{MaxAttempts: 3}, true, invalidCredentials, 1},
+		{&RetryPolicy{MaxAttempts: 3}, false, &unsentError{network}, 3},
+	}
+	for _, test := range tests {
+		lc := &LDAPClient{RetryPolicy: test.policy}
+		attempts := 0
+		err := lc.retry(test.idempotent, func() error {
+			attempts++
+			return test.err
+		})
+		if unsent, ok := test.err.(*unsentError); ok {
+			test.err = unsent.err
+		}
+		if err != test.err {
+			t.Errorf("expected %v, got %v", test.err, err)
+		}
+		if attempts != test.attempts {
+			t.Errorf("expected %d attempts for result code %d, got %d", test.attempts, test.err.(*ldap.Error).ResultCode, attempts)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	rp := &RetryPolicy{BaseDelay: 10 * time.Millisecond, Jitter: 5 * time.Millisecond}
+
+	for retry, base := range []time.Duration{10, 20, 40} {
+		delay := rp.delay(retry + 1)
+		if delay < base*time.Millisecond || delay >= (base+5)*time.Millisecond {
+			t.Errorf("unexpected delay %s for retry %d", delay, retry+1)
+		}
+	}
+
+	// The delay is capped instead of overflowing
+	rp = &RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}
+	for _, retry := range []int{7, 64, 1000} {
+		if delay := rp.delay(retry); delay != time.Minute {
+			t.Errorf("expected the delay of retry %d to be capped, got %s", retry, delay)
+		}
+	}
+	rp.MaxDelay = 0
+	if delay := rp.delay(100); delay != defaultMaxDelay {
+		t.Errorf("expected the default cap, got %s", delay)
+	}
+}
+
+func TestRetryDial(t *testing.T) {
+	dials := 0
+	lc := &LDAPClient{
+		Host:        "127.0.0.1",
+		Port:        deadPort(t),
+		SkipTLS:     true,
+		RetryPolicy: &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		Observe: func(op string, duration time.Duration, err error) {
+			if op == "connect" {
+				dials++
+			}
+		},
+	}
+
+	// Dialing is retried by the operation only, not once more per attempt
+	if _, err := lc.search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", nil, nil)); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if dials != 3 {
+		t.Errorf("expected 3 dials for a search, got %d", dials)
+	}
+
+	// A write that could not be sent is retried too
+	dials = 0
+	if err := lc.del(ldap.NewDelRequest("cn=test,dc=example,dc=com", nil)); !ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+		t.Fatalf("expected a network error, got %v", err)
+	}
+	if dials != 3 {
+		t.Errorf("expected 3 dials for a write, got %d", dials)
+	}
+}
@@ -0,0 +1,153 @@
+package ldap
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+)
+
+// Authentication methods, see LDAPClient.AuthMethod.
+const (
+	AuthSimple    = "simple"
+	AuthDigestMD5 = "DIGEST-MD5"
+	AuthGSSAPI    = "GSSAPI"
+	AuthNTLM      = "NTLM"
+)
+
+// BindSASL binds the connection with a SASL mechanism: AuthDigestMD5 with
+// the username and password, the realm being negotiated with the server, or
+// AuthGSSAPI with the Kerberos ticket cache, ignoring username and password.
+func (lc *LDAPClient) BindSASL(mechanism, username, password string) error {
+	err := lc.Connect()
+	if err != nil {
+		return err
+	}
+
+	return lc.saslBind(mechanism, username, password)
+}
+
+// saslBind binds the connection with a SASL mechanism.
+func (lc *LDAPClient) saslBind(mechanism, username, password string) error {
+	lc.boundAsBindUser = false
+	lc.boundDN = ""
+	start := time.Now()
+	var err error
+	switch mechanism {
+	case AuthDigestMD5:
+		_, err = lc.Conn.DigestMD5Bind(&ldap.DigestMD5BindRequest{
+			Host:     lc.currentHost(),
+			Username: username,
+			Password: password,
+		})
+	case AuthGSSAPI:
+		err = lc.gssapiBind()
+	default:
+		err = fmt.Errorf("Unsupported SASL mechanism %s", mechanism)
+	}
+	lc.trace("bind", fmt.Sprintf("mechanism=%s user=%q", mechanism, username), start, err)
+	if err == nil {
+		lc.boundDN = username
+	}
+	lc.failover(err)
+	return err
+}
+
+// gssapiBind binds the connection with the Kerberos keytab, if any, or else
+// ticket cache.
+func (lc *LDAPClient) gssapiBind() error {
+	var client *gssapi.Client
+	var err error
+	if lc.Krb5KeytabPath != "" {
+		username, realm, principalErr := lc.keytabPrincipal()
+		if principalErr != nil {
+			return principalErr
+		}
+		client, err = gssapi.NewClientWithKeytab(username, realm, lc.Krb5KeytabPath, lc.krb5ConfPath())
+	} else {
+		client, err = gssapi.NewClientFromCCache(lc.krb5CCachePath(), lc.krb5ConfPath())
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return lc.Conn.GSSAPIBind(client, lc.servicePrincipal(), "")
+}
+
+// BindNTLM binds the connection with NTLM as username in domain.
+// It returns ErrInvalidCredentials when the server rejects the credentials.
+func (lc *LDAPClient) BindNTLM(domain, username, password string) error {
+	err := lc.Connect()
+	if err != nil {
+		return err
+	}
+
+	return lc.ntlmBind(domain, username, password)
+}
+
+// ntlmBind runs the NTLM negotiate, challenge and response exchange.
+func (lc *LDAPClient) ntlmBind(domain, username, password string) error {
+	lc.boundAsBindUser = false
+	lc.boundDN = ""
+	start := time.Now()
+	err := lc.Conn.NTLMBind(domain, username, password)
+	lc.trace("bind", fmt.Sprintf("mechanism=NTLM user=%q", domain+`\`+username), start, err)
+	if err == nil {
+		lc.boundDN = domain + `\` + username
+	}
+	lc.failover(err)
+	if accountLocked(err) {
+		return ErrAccountLocked
+	}
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// currentHost returns the host of the current or next connection.
+func (lc *LDAPClient) currentHost() string {
+	hosts := lc.hosts()
+	return hosts[lc.hostIndex%len(hosts)]
+}
+
+func (lc *LDAPClient) servicePrincipal() string {
+	if lc.ServicePrincipal != "" {
+		return lc.ServicePrincipal
+	}
+	return "ldap/" + lc.currentHost()
+}
+
+// keytabPrincipal returns the username and realm of Krb5Principal, the
+// realm defaulting to Krb5Realm.
+func (lc *LDAPClient) keytabPrincipal() (string, string, error) {
+	username, realm := lc.Krb5Principal, lc.Krb5Realm
+	if i := strings.LastIndex(username, "@"); i >= 0 {
+		username, realm = username[:i], username[i+1:]
+	}
+	if username == "" || realm == "" {
+		return "", "", fmt.Errorf("Invalid Kerberos principal %q, a realm is required", lc.Krb5Principal)
+	}
+	return username, realm, nil
+}
+
+func (lc *LDAPClient) krb5ConfPath() string {
+	if lc.Krb5ConfPath != "" {
+		return lc.Krb5ConfPath
+	}
+	return "/etc/krb5.conf"
+}
+
+func (lc *LDAPClient) krb5CCachePath() string {
+	if lc.Krb5CCachePath != "" {
+		return lc.Krb5CCachePath
+	}
+	if ccache := os.Getenv("KRB5CCNAME"); ccache != "" {
+		return strings.TrimPrefix(ccache, "FILE:")
+	}
+	return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+}
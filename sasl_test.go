@@ -0,0 +1,54 @@
+package ldap
+
+import (
+	"os"
+	"testing"
+)
+
+func TestServicePrincipal(t *testing.T) {
+	lc := &LDAPClient{Hosts: []string{"dc1.example.com", "dc2.example.com"}, hostIndex: 1}
+	if spn := lc.servicePrincipal(); spn != "ldap/dc2.example.com" {
+		t.Errorf("expected ldap/dc2.example.com, got %s", spn)
+	}
+
+	lc.ServicePrincipal = "ldap/example.com"
+	if spn := lc.servicePrincipal(); spn != "ldap/example.com" {
+		t.Errorf("expected ldap/example.com, got %s", spn)
+	}
+}
+
+func TestKrb5CCachePath(t *testing.T) {
+	defer os.Setenv("KRB5CCNAME", os.Getenv("KRB5CCNAME"))
+	os.Setenv("KRB5CCNAME", "FILE:/run/user/1000/krb5cc")
+
+	lc := &LDAPClient{}
+	if path := lc.krb5CCachePath(); path != "/run/user/1000/krb5cc" {
+		t.Errorf("expected the KRB5CCNAME file, got %s", path)
+	}
+
+	lc.Krb5CCachePath = "/tmp/cache"
+	if path := lc.krb5CCachePath(); path != "/tmp/cache" {
+		t.Errorf("expected /tmp/cache, got %s", path)
+	}
+}
+
+func TestKeytabPrincipal(t *testing.T) {
+	tests := []struct {
+		principal, realm   string
+		username, expected string
+		valid              bool
+	}{
+		{"svc-sync@EXAMPLE.COM", "", "svc-sync", "EXAMPLE.COM", true},
+		{"svc-sync", "EXAMPLE.COM", "svc-sync", "EXAMPLE.COM", true},
+		{"svc-sync@CORP.EXAMPLE.COM", "EXAMPLE.COM", "svc-sync", "CORP.EXAMPLE.COM", true},
+		{"svc-sync", "", "", "", false},
+		{"", "EXAMPLE.COM", "", "", false},
+	}
+	for _, test := range tests {
+		lc := &LDAPClient{Krb5Principal: test.principal, Krb5Realm: test.realm}
+		username, realm, err := lc.keytabPrincipal()
+		if (err == nil) != test.valid || username != test.username || realm != test.expected {
+			t.Errorf("expected %q in %q for %q, got %q in %q, %v", test.username, test.expected, test.principal, username, realm, err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package ldap
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// responseTags maps the tag of a request to the tag of its result.
+var responseTags = map[ber.Tag]ber.Tag{
+	ldap.ApplicationBindRequest:     ldap.ApplicationBindResponse,
+	ldap.ApplicationSearchRequest:   ldap.ApplicationSearchResultDone,
+	ldap.ApplicationModifyRequest:   ldap.ApplicationModifyResponse,
+	ldap.ApplicationAddRequest:      ldap.ApplicationAddResponse,
+	ldap.ApplicationDelRequest:      ldap.ApplicationDelResponse,
+	ldap.ApplicationModifyDNRequest: ldap.ApplicationModifyDNResponse,
+	ldap.ApplicationCompareRequest:  ldap.ApplicationCompareResponse,
+	ldap.ApplicationExtendedRequest: ldap.ApplicationExtendedResponse,
+}
+
+// handler answers a request, sent with the given controls, with messages.
+type handler func(id int64, request *ber.Packet, controls []ldap.Control) []*ber.Packet
+
+// serve starts an LDAP server answering every request with the messages
+// returned by handle, and stops it when the test ends. It returns the port.
+func serve(t *testing.T, handle handler) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go answer(conn, handle)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// deadPort returns a port of 127.0.0.1 that refuses connections, as its
+// listener is closed, on every platform unlike other loopback addresses.
+func deadPort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+// answer reads the requests on conn until it is closed or unbound.
+func answer(conn net.Conn, handle handler) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+		var controls []ldap.Control
+		if len(packet.Children) > 2 {
+			for _, child := range packet.Children[2].Children {
+				controls = append(controls, requestControl(child))
+			}
+		}
+		switch request.Tag {
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationAbandonRequest:
+			continue
+		}
+		for _, response := range handle(id, request, controls) {
+			if _, err := conn.Write(response.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// requestControl decodes a control sent by the client. Controls without
+// value are not decoded by ldap.DecodeControl, as it expects responses.
+func requestControl(packet *ber.Packet) ldap.Control {
+	if len(packet.Children) == 3 || len(packet.Children) == 2 && packet.Children[1].Tag == ber.TagOctetString {
+		if control, err := ldap.DecodeControl(packet); err == nil {
+			return control
+		}
+	}
+	control := &ldap.ControlString{ControlType: packet.Children[0].Data.String()}
+	if len(packet.Children) > 1 {
+		control.Criticality, _ = packet.Children[1].Value.(bool)
+	}
+	return control
+}
+
+// ldapMessage wraps a protocol operation and its controls in a message.
+func ldapMessage(id int64, op *ber.Packet, controls ...ldap.Control) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	packet.AppendChild(op)
+	if len(controls) > 0 {
+		// Children are encoded when appended, so complete them first
+		packet.AppendChild(encodeControls(controls))
+	}
+	return packet
+}
+
+// encodeControls returns the controls element of a message.
+func encodeControls(controls []ldap.Control) *ber.Packet {
+	packet := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+	for _, control := range controls {
+		packet.AppendChild(control.Encode())
+	}
+	return packet
+}
+
+// ldapResult returns the result of request with code and controls.
+func ldapResult(id int64, request *ber.Packet, code uint16, controls ...ldap.Control) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, responseTags[request.Tag], nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(code), "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return ldapMessage(id, op, controls...)
+}
+
+// ldapEntry returns a search result entry with the given attributes.
+func ldapEntry(id int64, dn string, attributes map[string][]string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+	sequence := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, name := range sortedNames(attributes) {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range attributes[name] {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(values)
+		sequence.AppendChild(attribute)
+	}
+	op.AppendChild(sequence)
+	return ldapMessage(id, op)
+}
+
+// ldapReference returns a search result reference to the given URLs.
+func ldapReference(id int64, urls ...string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultReference, nil, "Search Result Reference")
+	for _, url := range urls {
+		op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, url, "URL"))
+	}
+	return ldapMessage(id, op)
+}
+
+// bindRequest returns the DN and password of a simple bind request.
+func bindRequest(request *ber.Packet) (string, string) {
+	if request.Tag != ldap.ApplicationBindRequest || len(request.Children) < 3 {
+		return "", ""
+	}
+	return request.Children[1].Data.String(), request.Children[2].Data.String()
+}
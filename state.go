@@ -0,0 +1,51 @@
+package ldap
+
+import "crypto/tls"
+
+// State describes the connection of a client, e.g. to check that it runs
+// over TLS and is bound as expected.
+type State struct {
+	Connected bool
+	// Host is the host connected to, among Hosts.
+	Host string
+	// TLSActive is set for LDAPS and StartTLS connections, along with the
+	// negotiated TLSVersion and the ServerName verified.
+	TLSActive  bool
+	TLSVersion uint16
+	ServerName string
+	// BoundDN is the DN, or SASL or NTLM username, of the last successful
+	// bind, empty when anonymous.
+	BoundDN string
+	// VendorName and VendorVersion are read from the root DSE, when the
+	// server publishes them.
+	VendorName    string
+	VendorVersion string
+}
+
+// State returns the state of the connection, without connecting or binding.
+// The vendor is read from the root DSE with the current bind.
+func (lc *LDAPClient) State() (State, error) {
+	if lc.Conn == nil {
+		return State{}, nil
+	}
+
+	state := State{
+		Connected: true,
+		Host:      lc.currentHost(),
+		BoundDN:   lc.boundDN,
+	}
+	var connectionState tls.ConnectionState
+	connectionState, state.TLSActive = lc.Conn.TLSConnectionState()
+	if state.TLSActive {
+		state.TLSVersion = connectionState.Version
+		state.ServerName = connectionState.ServerName
+	}
+
+	entry, err := lc.readEntry("", []string{"vendorName", "vendorVersion"})
+	if err != nil {
+		return state, err
+	}
+	state.VendorName = entry.GetAttributeValue("vendorName")
+	state.VendorVersion = entry.GetAttributeValue("vendorVersion")
+	return state, nil
+}
@@ -0,0 +1,17 @@
+package ldap
+
+import "testing"
+
+func TestStateNotConnected(t *testing.T) {
+	lc := &LDAPClient{Host: "ldap.example.com", boundDN: "cn=reader,dc=example,dc=com"}
+	state, err := lc.State()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state != (State{}) {
+		t.Errorf("expected an empty state, got %+v", state)
+	}
+	if lc.Conn != nil {
+		t.Errorf("expected State not to connect")
+	}
+}